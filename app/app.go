@@ -3,6 +3,7 @@ package app
 import (
 	"dilogger/internal/db"
 	"dilogger/internal/product"
+	"dilogger/internal/utils"
 	"embed"
 )
 
@@ -19,5 +20,30 @@ func Run() {
 	AddHourlyJob(server, "pricelogger", func() {
 		product.ReloadData(server)
 	})
+	AddJob(server, "pricerollup", "30 * * * *", func() {
+		server.RollupDailyPrices()
+	})
+	AddJob(server, "quiethours", "*/5 * * * *", func() {
+		server.ReleaseHeldNotifications()
+	})
+	AddJob(server, "notifyretry", "* * * * *", func() {
+		server.RetryNotifications()
+	})
+	AddJob(server, "digest", "*/5 * * * *", func() {
+		server.SendDigests()
+	})
+	AddJob(server, "retention", "0 3 * * *", func() {
+		dryRun := utils.GetEnv("RETENTION_DRY_RUN", "false") == "true"
+		if _, err := product.ApplyRetention(server, dryRun); err != nil {
+			server.Logger().Error(err.Error())
+		}
+	})
+	if schedule := utils.GetEnv("BACKUP_SCHEDULE", "0 2 * * *"); schedule != "off" {
+		AddJob(server, "backup", schedule, func() {
+			if _, err := product.Backup(server); err != nil {
+				server.Logger().Error(err.Error())
+			}
+		})
+	}
 	Start(server)
 }
@@ -18,6 +18,7 @@ import (
 	"runtime"
 	"slices"
 	"strings"
+	"time"
 
 	"github.com/pocketbase/pocketbase/apis"
 	"github.com/pocketbase/pocketbase/core"
@@ -30,6 +31,9 @@ func Start(s *db.Server) error {
 	s.AddCobraCommand(NewServeCommand(s.App))
 	s.AddCobraCommand(NewStartCommand(s.App, false))
 	s.AddCobraCommand(NewStopCommand(s.App))
+	s.AddCobraCommand(NewRetentionCommand(s))
+	s.AddCobraCommand(NewBackupCommand(s))
+	s.AddCobraCommand(NewRestoreCommand(s))
 	return s.App.Execute()
 }
 
@@ -40,15 +44,15 @@ func NewInitCommand(server *db.Server) *cobra.Command {
 		Short:        "Starts the web server (default to 127.0.0.1:8090 if no domain is specified)",
 		SilenceUsage: true,
 		Run: func(cmd *cobra.Command, args []string) {
-			server.NewUrlCollection()
-			server.NewProductCollection()
-			server.NewPriceCollection()
+			server.InitCollections()
+			server.InitWebPush()
 			InitSettings(server.App)
 			AddUser(server.App, "_superusers")
 			AddUser(server.App, "users")
 			AddURL(server.App, "https://www.designinfo.in/wishlist/view/f6a054/")
 			AddURL(server.App, "https://www.designinfo.in/wishlist/view/da0c1e/")
 			product.ReloadData(server)
+			server.RollupDailyPrices()
 		},
 	}
 	return command
@@ -333,6 +337,83 @@ func NewStopCommand(app core.App) *cobra.Command {
 	return command
 }
 
+// Creates a command to apply the retention rules, reporting what would be deleted on a dry run
+func NewRetentionCommand(server *db.Server) *cobra.Command {
+	var dryRun bool
+	command := &cobra.Command{
+		Use:          "retention",
+		Args:         cobra.NoArgs,
+		Short:        "Deletes records older than the RETENTION_RULES and vacuums the database",
+		SilenceUsage: true,
+		RunE: func(command *cobra.Command, args []string) error {
+			reports, err := product.ApplyRetention(server, dryRun)
+			for _, report := range reports {
+				action := "deleted"
+				if report.DryRun {
+					action = "would delete"
+				}
+				fmt.Printf("%s: %s %d records older than %s\n", report.Collection, action, report.Matched, report.Cutoff.Format(time.DateOnly))
+			}
+			return err
+		},
+	}
+
+	command.PersistentFlags().BoolVar(
+		&dryRun,
+		"dry-run",
+		false,
+		"Only report the records that would be deleted",
+	)
+
+	return command
+}
+
+// Creates a command to write a timestamped backup to the BACKUP_DIR and rotate the old ones
+func NewBackupCommand(server *db.Server) *cobra.Command {
+	command := &cobra.Command{
+		Use:          "backup",
+		Args:         cobra.NoArgs,
+		Short:        "Creates a backup archive of the data directory",
+		SilenceUsage: true,
+		RunE: func(command *cobra.Command, args []string) error {
+			path, err := product.Backup(server)
+			if err != nil {
+				return err
+			}
+			fmt.Println("Backup created:", path)
+			return nil
+		},
+	}
+	return command
+}
+
+// Creates a command to restore the data directory from a backup archive
+func NewRestoreCommand(server *db.Server) *cobra.Command {
+	var force bool
+	command := &cobra.Command{
+		Use:          "restore [archive]",
+		Args:         cobra.ExactArgs(1),
+		Short:        "Restores the data directory from a backup archive (the server must be stopped)",
+		SilenceUsage: true,
+		RunE: func(command *cobra.Command, args []string) error {
+			if err := server.RestoreBackup(args[0], force); err != nil {
+				return err
+			}
+			fmt.Println("Backup restored:", args[0])
+			return nil
+		},
+	}
+
+	command.PersistentFlags().BoolVar(
+		&force,
+		"force",
+		false,
+		"Restore even when the pid file names a running process",
+	)
+
+	return command
+}
+
 // Verify the data received back from background process
 func handlePingbackConn(conn net.Conn, expect []byte) error {
 	defer conn.Close()
@@ -5,10 +5,10 @@ let chartXRange = "timestamp";
 let timeRangeDays = 1;
 let selectedProduct = "";
 let urlCheckboxSelected = [];
+let oneSignalReady = null;
 const refreshRate = 60; // minutes
 const toggle = (handle, a, b) => (handle == a ? b : a);
 const destroyChart = () => (chart !== null ? chart.destroy() : null);
-const newItem = (item, time) => ({ price: item.price, time });
 pb.autoCancellation(false);
 
 const loginBtn = document.getElementById("login-btn");
@@ -64,18 +64,7 @@ async function drawChart() {
 
 // Prepare data to draw chart
 async function loadProductData() {
-  const predata = await fetchPrices();
-  let data = [];
-  predata.forEach((item) => {
-    const created = item.created;
-    const updated = item.updated;
-    if (created == updated) {
-      data.push(newItem(item, created));
-    } else {
-      data.push(newItem(item, created));
-      data.push(newItem(item, updated));
-    }
-  });
+  const data = await fetchPrices();
   const reversedData = [...data].reverse();
   const labels = data.map((p) => new Date(p.time));
   const prices = data.map((p) => p.price);
@@ -112,7 +101,7 @@ async function loadProductData() {
 }
 
 // Populate fetched product data in to a list group
-function updateProductList(products) {
+function updateProductList(products, stats = {}) {
   const list = document.getElementById("product-list");
   list.innerHTML = "";
   if (products != null) {
@@ -120,6 +109,21 @@ function updateProductList(products) {
       const li = document.createElement("li");
       li.setAttribute("data-id", product.id);
       li.textContent = product.name;
+      if (stats[product.id]?.at_30_day_low) {
+        const badge = document.createElement("span");
+        badge.className = "badge bg-success ms-2";
+        badge.title = "Current price is the lowest in the last 30 days";
+        badge.textContent = "30-day low";
+        li.appendChild(badge);
+      }
+      if (product.archived) {
+        const badge = document.createElement("span");
+        badge.className = "badge bg-secondary ms-2";
+        badge.title = "No longer listed on any tracked wishlist";
+        badge.textContent = "Archived";
+        li.classList.add("archived");
+        li.appendChild(badge);
+      }
       li.onclick = () => {
         document
           .querySelectorAll("#product-list li")
@@ -145,18 +149,42 @@ function reloadProduct() {
   }
 }
 
-// Fetch prices from database
+// Select the product linked by the "product" query parameter of notifications
+function selectLinkedProduct() {
+  const id = new URLSearchParams(window.location.search).get("product");
+  const item = id && document.querySelector(`#product-list li[data-id="${CSS.escape(id)}"]`);
+  if (item) {
+    item.click();
+    item.scrollIntoView({ block: "nearest" });
+  }
+}
+
+// Fetch price history from server
 async function fetchPrices() {
+  const query = {};
+  if (chartXRange != "timestamp") {
+    const from = new Date();
+    from.setDate(from.getDate() - timeRangeDays);
+    query.from = from.toISOString();
+  }
   try {
     return (
-      await pb.collection("prices").getList(1, 50, {
-        sort: "updated",
-        filter: 'product~"' + selectedProduct + '"',
-        fields: "price,created,updated",
-      })
-    )["items"];
+      await pb.send("/api/products/" + selectedProduct + "/history", { query })
+    )["points"];
   } catch (error) {
     console.warn("Error loading product data:", error);
+    return [];
+  }
+}
+
+// Fetch price statistics of all products mapped by product id
+async function fetchStats() {
+  try {
+    const stats = await pb.send("/api/products/stats", {});
+    return Object.fromEntries(stats.map((item) => [item.product, item]));
+  } catch (error) {
+    console.warn("Error loading statistics:", error);
+    return {};
   }
 }
 
@@ -164,15 +192,205 @@ async function fetchPrices() {
 async function fetchProducts() {
   try {
     const records = await pb.collection("products").getFullList({
-      sort: "-created",
-      fields: "id,name",
+      sort: "archived,-created",
+      fields: "id,name,archived",
     });
-    updateProductList(records);
+    updateProductList(records, await fetchStats());
+    selectLinkedProduct();
   } catch (error) {
     console.warn(error);
   }
 }
 
+// Decode the base64url VAPID public key for the push manager
+function decodeKey(key) {
+  const base64 = (key + "=".repeat((4 - (key.length % 4)) % 4))
+    .replace(/-/g, "+")
+    .replace(/_/g, "/");
+  return Uint8Array.from(atob(base64), (c) => c.charCodeAt(0));
+}
+
+// Get the push subscription of this browser, if any
+async function getPushSubscription() {
+  const registration = await navigator.serviceWorker.getRegistration("/");
+  return registration ? await registration.pushManager.getSubscription() : null;
+}
+
+// Show whether this browser receives the price alerts
+async function updatePushStatus() {
+  const status = document.getElementById("push-status");
+  const button = document.getElementById("push-toggle-btn");
+  if (!("serviceWorker" in navigator) || !("PushManager" in window)) {
+    status.textContent = "This browser does not support push notifications.";
+    button.classList.add("hidden");
+    return;
+  }
+  const subscription = await getPushSubscription();
+  status.textContent = subscription
+    ? "Browser alerts are enabled."
+    : "Browser alerts are disabled.";
+  button.textContent = subscription
+    ? "Disable browser alerts"
+    : "Enable browser alerts";
+}
+
+// Subscribe this browser to the price alerts or unsubscribe it
+async function togglePushSubscription() {
+  try {
+    const subscription = await getPushSubscription();
+    if (subscription) {
+      await pb.send("/api/push/subscriptions", {
+        method: "DELETE",
+        body: { endpoint: subscription.endpoint },
+      }).catch(() => null);
+      await subscription.unsubscribe();
+    } else {
+      const { public_key } = await pb.send("/api/push/vapid-key", {});
+      await navigator.serviceWorker.register("/sw.js");
+      const registration = await navigator.serviceWorker.ready;
+      const created = await registration.pushManager.subscribe({
+        userVisibleOnly: true,
+        applicationServerKey: decodeKey(public_key),
+      });
+      await pb.send("/api/push/subscriptions", {
+        method: "POST",
+        body: created.toJSON(),
+      });
+    }
+  } catch (error) {
+    console.warn("Error changing push subscription:", error);
+    document.getElementById("push-status").textContent = error.message;
+    return;
+  }
+  updatePushStatus();
+}
+
+// Save the OneSignal subscription of this browser for the logged in user, which tells the server that the user can receive pushes.
+// The subscription is removed when the browser opted out, the other browsers of the user keep theirs.
+async function saveOneSignalSubscription(event) {
+  if (!pb.authStore.record) {
+    return;
+  }
+  const subscription = window.OneSignal.User.PushSubscription;
+  const previous = event?.previous?.id;
+  if (previous && previous !== subscription.id) {
+    await deleteOneSignalSubscription(previous);
+  }
+  if (!subscription.id) {
+    return;
+  }
+  if (!subscription.optedIn) {
+    await deleteOneSignalSubscription(subscription.id);
+    return;
+  }
+  await pb
+    .send("/api/onesignal/subscriptions", {
+      method: "POST",
+      body: { id: subscription.id },
+    })
+    .catch((error) => console.warn("Error linking OneSignal:", error));
+}
+
+// Remove a OneSignal subscription of the logged in user, if the server knows it
+async function deleteOneSignalSubscription(id) {
+  await pb
+    .send("/api/onesignal/subscriptions", { method: "DELETE", body: { id } })
+    .catch(() => null);
+}
+
+// Load the OneSignal SDK once, resolves to the SDK or to null when OneSignal is not configured
+function loadOneSignal() {
+  oneSignalReady ??= pb
+    .send("/api/onesignal/config", {})
+    .then(
+      (config) =>
+        new Promise((resolve) => {
+          window.OneSignalDeferred = window.OneSignalDeferred || [];
+          OneSignalDeferred.push(async (OneSignal) => {
+            await OneSignal.init({
+              appId: config.app_id,
+              serviceWorkerPath: "onesignal/OneSignalSDKWorker.js",
+              serviceWorkerParam: { scope: "/onesignal/" },
+            });
+            OneSignal.User.PushSubscription.addEventListener(
+              "change",
+              saveOneSignalSubscription
+            );
+            resolve(OneSignal);
+          });
+          const script = document.createElement("script");
+          script.src =
+            "https://cdn.onesignal.com/sdks/web/v16/OneSignalSDK.page.js";
+          script.defer = true;
+          document.head.appendChild(script);
+        })
+    )
+    .catch(() => null);
+  return oneSignalReady;
+}
+
+// Link this browser to the OneSignal external id of the logged in user, when OneSignal is configured.
+// The external id is proven by a token signed by the server when identity verification is configured.
+async function linkOneSignal() {
+  const OneSignal = await loadOneSignal();
+  if (!OneSignal || !pb.authStore.isValid || !pb.authStore.record) {
+    return;
+  }
+  try {
+    const { token } = await pb
+      .send("/api/onesignal/token", {})
+      .catch(() => ({}));
+    await OneSignal.login(pb.authStore.record.id, token);
+    await saveOneSignalSubscription();
+  } catch (error) {
+    console.warn("Error linking OneSignal:", error);
+  }
+}
+
+// Unlink this browser from the OneSignal user on logout, before the auth store is cleared
+async function unlinkOneSignal() {
+  const OneSignal = await oneSignalReady;
+  if (OneSignal) {
+    if (OneSignal.User.PushSubscription.id) {
+      await deleteOneSignalSubscription(OneSignal.User.PushSubscription.id);
+    }
+    await OneSignal.logout().catch(() => null);
+  }
+}
+
+// Show the digest settings of the logged in user
+function loadDigestSettings() {
+  const user = pb.authStore.record;
+  document.getElementById("digest-form").classList.toggle("hidden", !user);
+  document.getElementById("digest-status").textContent = user
+    ? ""
+    : "Log in to receive digests.";
+  if (!user) return;
+  document.getElementById("digest-period").value = user.digest || "";
+  document.getElementById("digest-day").value = user.digest_day || "monday";
+  document.getElementById("digest-time").value = user.digest_time || "08:00";
+  document.getElementById("digest-day").disabled = user.digest !== "weekly";
+}
+
+// Save the digest settings in the timezone of this browser
+async function saveDigestSettings(e) {
+  e.preventDefault();
+  const status = document.getElementById("digest-status");
+  try {
+    await pb.collection("users").update(pb.authStore.record.id, {
+      digest: document.getElementById("digest-period").value,
+      digest_day: document.getElementById("digest-day").value,
+      digest_time: document.getElementById("digest-time").value,
+      timezone: Intl.DateTimeFormat().resolvedOptions().timeZone,
+    });
+    status.textContent = "Digest settings saved.";
+  } catch (error) {
+    console.warn("Error saving digest settings:", error);
+    status.textContent = error.message;
+  }
+  loadDigestSettings();
+}
+
 // Loads all urls to settings
 async function loadUrls() {
   try {
@@ -237,6 +455,7 @@ function checkAuth() {
         <path fill-rule="evenodd" d="M6 12.5a.5.5 0 0 0 .5.5h8a.5.5 0 0 0 .5-.5v-9a.5.5 0 0 0-.5-.5h-8a.5.5 0 0 0-.5.5v2a.5.5 0 0 1-1 0v-2A1.5 1.5 0 0 1 6.5 2h8A1.5 1.5 0 0 1 16 3.5v9a1.5 1.5 0 0 1-1.5 1.5h-8A1.5 1.5 0 0 1 5 12.5v-2a.5.5 0 0 1 1 0z"/>
         <path fill-rule="evenodd" d="M.146 8.354a.5.5 0 0 1 0-.708l3-3a.5.5 0 1 1 .708.708L1.707 7.5H10.5a.5.5 0 0 1 0 1H1.707l2.147 2.146a.5.5 0 0 1-.708.708z"/>
         </svg>`;
+    linkOneSignal();
   }
 }
 
@@ -297,6 +516,18 @@ addEventListener("load", (event) => {
     });
   };
 
+  // Toggle browser push alerts
+  document
+    .getElementById("push-toggle-btn")
+    .addEventListener("click", togglePushSubscription);
+  updatePushStatus();
+  document
+    .getElementById("digest-form")
+    .addEventListener("submit", saveDigestSettings);
+  document.getElementById("digest-period").addEventListener("change", (e) => {
+    document.getElementById("digest-day").disabled = e.target.value !== "weekly";
+  });
+
   // Add URL on Button Click
   document.getElementById("add-url-btn").addEventListener("click", async () => {
     const newUrl = document.getElementById("new-url").value;
@@ -319,6 +550,7 @@ addEventListener("load", (event) => {
   document
     .getElementById("settings-btn")
     .addEventListener("click", async () => {
+      loadDigestSettings();
       await loadUrls();
     });
 
@@ -335,8 +567,9 @@ addEventListener("load", (event) => {
   });
 
   // Login Button on Click
-  loginBtn.addEventListener("click", () => {
+  loginBtn.addEventListener("click", async () => {
     if (pb.authStore.isValid) {
+      await unlinkOneSignal();
       pb.authStore.clear();
       document.getElementById("username-tag").textContent = "";
       document.querySelector(".welcome-user").classList.add("hidden");
@@ -365,6 +598,7 @@ addEventListener("load", (event) => {
           <path fill-rule="evenodd" d="M6 12.5a.5.5 0 0 0 .5.5h8a.5.5 0 0 0 .5-.5v-9a.5.5 0 0 0-.5-.5h-8a.5.5 0 0 0-.5.5v2a.5.5 0 0 1-1 0v-2A1.5 1.5 0 0 1 6.5 2h8A1.5 1.5 0 0 1 16 3.5v9a1.5 1.5 0 0 1-1.5 1.5h-8A1.5 1.5 0 0 1 5 12.5v-2a.5.5 0 0 1 1 0z"/>
           <path fill-rule="evenodd" d="M.146 8.354a.5.5 0 0 1 0-.708l3-3a.5.5 0 1 1 .708.708L1.707 7.5H10.5a.5.5 0 0 1 0 1H1.707l2.147 2.146a.5.5 0 0 1-.708.708z"/>
           </svg>`;
+        linkOneSignal();
       }
     } catch (err) {
       alert("Login failed: " + err.message);
@@ -0,0 +1,18 @@
+// Show the price alerts sent by the server
+self.addEventListener("push", (event) => {
+  const data = event.data ? event.data.json() : {};
+  event.waitUntil(
+    self.registration.showNotification(data.title || "Price alert", {
+      body: data.body,
+      tag: data.tag,
+      data: { url: data.url || "/" },
+      icon: "/static/icons/android-chrome-192x192.png",
+    })
+  );
+});
+
+// Open the price chart of the product on click
+self.addEventListener("notificationclick", (event) => {
+  event.notification.close();
+  event.waitUntil(clients.openWindow(event.notification.data.url));
+});
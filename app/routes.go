@@ -1,15 +1,22 @@
 package app
 
 import (
+	"dilogger/internal/alert"
 	"dilogger/internal/db"
 	"dilogger/internal/product"
+	"dilogger/internal/push"
+	"dilogger/internal/series"
 	"dilogger/internal/utils"
+	"errors"
 	"fmt"
 	"io/fs"
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"syscall"
+	"time"
 
 	"github.com/pocketbase/pocketbase/apis"
 	"github.com/pocketbase/pocketbase/core"
@@ -81,14 +88,297 @@ func AddStaticRoute(se *core.ServeEvent, sfs fs.FS) {
 		return
 	}
 	se.Router.GET("/static/{path...}", apis.Static(staticFS, false))
+	// the service worker is served from the root to receive push notifications for the whole UI
+	se.Router.GET("/sw.js", func(e *core.RequestEvent) error {
+		return e.FileFS(staticFS, "sw.js")
+	})
 }
 
 // Add route to reload product data
 func AddReloadRoute(se *core.ServeEvent, server *db.Server) {
 	se.Router.GET("/api/reload-data", func(e *core.RequestEvent) error {
-		product.ReloadData(server)
+		if !product.ReloadData(server) {
+			return e.JSON(http.StatusConflict, map[string]bool{
+				"reloaded": false,
+			})
+		}
 		return e.JSON(http.StatusOK, map[string]bool{
 			"reloaded": true,
 		})
 	})
 }
+
+// Add route to read the price history of a product
+func AddHistoryRoute(se *core.ServeEvent, server *db.Server) {
+	se.Router.GET("/api/products/{id}/history", func(e *core.RequestEvent) error {
+		bucket, from, to, err := parseHistoryQuery(e)
+		if err != nil {
+			return err
+		}
+		history, err := server.PriceHistory(e.Request.PathValue("id"), from, to, bucket)
+		if err != nil {
+			return e.NotFoundError("product not found", err)
+		}
+		return e.JSON(http.StatusOK, history)
+	})
+}
+
+// Add routes to read price statistics of all products or a single product
+func AddStatsRoute(se *core.ServeEvent, server *db.Server) {
+	se.Router.GET("/api/products/stats", func(e *core.RequestEvent) error {
+		stats, err := server.ProductStats()
+		if err != nil {
+			return e.InternalServerError("failed to compute statistics", err)
+		}
+		return e.JSON(http.StatusOK, stats)
+	})
+	se.Router.GET("/api/products/{id}/stats", func(e *core.RequestEvent) error {
+		stats, err := server.ProductStats(e.Request.PathValue("id"))
+		if err != nil {
+			return e.InternalServerError("failed to compute statistics", err)
+		}
+		if len(stats) == 0 {
+			return e.NotFoundError("no prices found for product", nil)
+		}
+		return e.JSON(http.StatusOK, stats[0])
+	})
+}
+
+// Add route to read the delivery statistics of the notification backends
+func AddNotificationStatsRoute(se *core.ServeEvent, server *db.Server) {
+	se.Router.GET("/api/notifications/backends", func(e *core.RequestEvent) error {
+		return e.JSON(http.StatusOK, server.Notification.Stats())
+	}).Bind(apis.RequireSuperuserAuth())
+}
+
+// Add routes to read the VAPID public key and to manage the browser push subscriptions of the user
+func AddWebPushRoutes(se *core.ServeEvent, server *db.Server) {
+	se.Router.GET("/api/push/vapid-key", func(e *core.RequestEvent) error {
+		keys, err := server.VAPIDKeys()
+		if err != nil {
+			return e.InternalServerError("web push is not available", err)
+		}
+		return e.JSON(http.StatusOK, map[string]string{"public_key": keys.PublicKey})
+	})
+	subscriptions := se.Router.Group("/api/push/subscriptions").Bind(apis.RequireAuth("users"))
+	subscriptions.POST("", func(e *core.RequestEvent) error {
+		data := struct {
+			Endpoint string `json:"endpoint"`
+			Keys     struct {
+				P256dh string `json:"p256dh"`
+				Auth   string `json:"auth"`
+			} `json:"keys"`
+		}{}
+		if err := e.BindBody(&data); err != nil {
+			return e.BadRequestError("failed to read request data", err)
+		}
+		if data.Endpoint == "" || data.Keys.P256dh == "" || data.Keys.Auth == "" {
+			return e.BadRequestError("endpoint, keys.p256dh and keys.auth are required", nil)
+		}
+		record, err := server.SavePushSubscription(push.Subscription{
+			User:     e.Auth.Id,
+			Endpoint: data.Endpoint,
+			P256dh:   data.Keys.P256dh,
+			Auth:     data.Keys.Auth,
+		}, e.Request.UserAgent())
+		if errors.Is(err, db.ErrSubscriptionTaken) {
+			return e.ForbiddenError("the subscription belongs to another user", nil)
+		}
+		if err != nil {
+			return e.BadRequestError("failed to save subscription", err)
+		}
+		return e.JSON(http.StatusOK, map[string]string{"id": record.Id})
+	})
+	subscriptions.DELETE("", func(e *core.RequestEvent) error {
+		data := struct {
+			Endpoint string `json:"endpoint"`
+		}{}
+		if err := e.BindBody(&data); err != nil {
+			return e.BadRequestError("failed to read request data", err)
+		}
+		record, err := e.App.FindFirstRecordByData("push_subscriptions", "endpoint", data.Endpoint)
+		if err != nil || record.GetString("user") != e.Auth.Id {
+			return e.NotFoundError("subscription not found", err)
+		}
+		if err := e.App.Delete(record); err != nil {
+			return e.InternalServerError("failed to delete subscription", err)
+		}
+		return e.NoContent(http.StatusNoContent)
+	})
+}
+
+// Add routes for the OneSignal web SDK, which links the logged in users to their OneSignal external id and stores their subscriptions
+func AddOneSignalRoutes(se *core.ServeEvent, server *db.Server) {
+	se.Router.GET("/api/onesignal/config", func(e *core.RequestEvent) error {
+		if !slices.Contains(server.Notification.Backends(), "onesignal") {
+			return e.NotFoundError("onesignal is not configured", nil)
+		}
+		return e.JSON(http.StatusOK, map[string]string{"app_id": utils.GetEnv("OS_APP_ID", "")})
+	})
+	se.Router.GET("/api/onesignal/token", func(e *core.RequestEvent) error {
+		token, err := push.OneSignalToken(e.Auth.Id)
+		if errors.Is(err, push.ErrNotConfigured) {
+			return e.NotFoundError("onesignal identity verification is not configured", nil)
+		}
+		if err != nil {
+			return e.InternalServerError("failed to sign onesignal token", err)
+		}
+		return e.JSON(http.StatusOK, map[string]string{"token": token})
+	}).Bind(apis.RequireAuth("users"))
+	subscriptions := se.Router.Group("/api/onesignal/subscriptions").Bind(apis.RequireAuth("users"))
+	subscriptions.POST("", func(e *core.RequestEvent) error {
+		data := struct {
+			Id string `json:"id"`
+		}{}
+		if err := e.BindBody(&data); err != nil {
+			return e.BadRequestError("failed to read request data", err)
+		}
+		if data.Id == "" {
+			return e.BadRequestError("id is required", nil)
+		}
+		record, err := server.SaveOneSignalSubscription(e.Auth.Id, data.Id, e.Request.UserAgent())
+		if errors.Is(err, db.ErrSubscriptionTaken) {
+			return e.ForbiddenError("the subscription belongs to another user", nil)
+		}
+		if err != nil {
+			return e.BadRequestError("failed to save subscription", err)
+		}
+		return e.JSON(http.StatusOK, map[string]string{"id": record.Id})
+	})
+	subscriptions.DELETE("", func(e *core.RequestEvent) error {
+		data := struct {
+			Id string `json:"id"`
+		}{}
+		if err := e.BindBody(&data); err != nil {
+			return e.BadRequestError("failed to read request data", err)
+		}
+		record, err := e.App.FindFirstRecordByData("onesignal_subscriptions", "subscription", data.Id)
+		if err != nil || record.GetString("user") != e.Auth.Id {
+			return e.NotFoundError("subscription not found", err)
+		}
+		if err := e.App.Delete(record); err != nil {
+			return e.InternalServerError("failed to delete subscription", err)
+		}
+		return e.NoContent(http.StatusNoContent)
+	})
+	// the OneSignal service worker gets its own scope, so it does not replace the web push service worker
+	se.Router.GET("/onesignal/OneSignalSDKWorker.js", func(e *core.RequestEvent) error {
+		e.Response.Header().Set("Content-Type", "application/javascript")
+		return e.String(http.StatusOK, `importScripts("https://cdn.onesignal.com/sdks/web/v16/OneSignalSDK.sw.js");`)
+	})
+}
+
+// Add routes to read the total value of the wishlists and its history
+func AddBasketRoutes(se *core.ServeEvent, server *db.Server) {
+	se.Router.GET("/api/baskets", func(e *core.RequestEvent) error {
+		baskets, err := server.Baskets()
+		if err != nil {
+			return e.InternalServerError("failed to read baskets", err)
+		}
+		return e.JSON(http.StatusOK, baskets)
+	})
+	se.Router.GET("/api/baskets/{id}/history", func(e *core.RequestEvent) error {
+		bucket, from, to, err := parseHistoryQuery(e)
+		if err != nil {
+			return err
+		}
+		history, err := server.BasketHistory(e.Request.PathValue("id"), from, to, bucket, e.Request.URL.Query().Get("in_stock") == "true")
+		if err != nil {
+			return e.NotFoundError("wishlist not found", err)
+		}
+		return e.JSON(http.StatusOK, history)
+	})
+}
+
+// Add route to compare the prices paid by the user with the 90-day average prices
+func AddSavingsRoute(se *core.ServeEvent, server *db.Server) {
+	se.Router.GET("/api/purchases/savings", func(e *core.RequestEvent) error {
+		report, err := server.PurchaseSavings(e.Auth.Id)
+		if err != nil {
+			return e.InternalServerError("failed to compute savings", err)
+		}
+		return e.JSON(http.StatusOK, report)
+	}).Bind(apis.RequireAuth("users"))
+}
+
+// Add route to replay the stored history through an alert rule before saving it
+func AddBacktestRoute(se *core.ServeEvent, server *db.Server) {
+	se.Router.POST("/api/alerts/backtest", func(e *core.RequestEvent) error {
+		data := struct {
+			Product    string  `json:"product"`
+			Condition  string  `json:"condition"`
+			Threshold  float64 `json:"threshold"`
+			Expression string  `json:"expression"`
+			From       string  `json:"from"`
+			To         string  `json:"to"`
+		}{}
+		if err := e.BindBody(&data); err != nil {
+			return e.BadRequestError("failed to read request data", err)
+		}
+		rule := alert.Rule{
+			Product:    data.Product,
+			Condition:  data.Condition,
+			Threshold:  data.Threshold,
+			Expression: data.Expression,
+		}
+		if err := rule.ValidateCondition(); err != nil {
+			return e.BadRequestError(err.Error(), err)
+		}
+		to, err := parseTimeParam(data.To, time.Now(), time.UTC)
+		if err != nil {
+			return e.BadRequestError("invalid to date", err)
+		}
+		from, err := parseTimeParam(data.From, to.AddDate(0, 0, -90), time.UTC)
+		if err != nil {
+			return e.BadRequestError("invalid from date", err)
+		}
+		if !from.Before(to) {
+			return e.BadRequestError("from date must be before to date", nil)
+		}
+		result, err := server.Backtest(rule, from, to)
+		if err != nil {
+			return e.NotFoundError("product not found", err)
+		}
+		return e.JSON(http.StatusOK, result)
+	}).Bind(apis.RequireAuth())
+}
+
+// Parse the bucket and the from and to dates of a history request, in the location of its tz parameter.
+// The error is the bad request error to return.
+func parseHistoryQuery(e *core.RequestEvent) (bucket series.Bucket, from, to time.Time, err error) {
+	query := e.Request.URL.Query()
+	location, err := time.LoadLocation(query.Get("tz"))
+	if err != nil {
+		return bucket, from, to, e.BadRequestError("invalid timezone", err)
+	}
+	bucket, err = series.ParseBucket(query.Get("bucket"))
+	if err != nil {
+		return bucket, from, to, e.BadRequestError(err.Error(), nil)
+	}
+	from, err = parseTimeParam(query.Get("from"), time.Unix(0, 0), location)
+	if err != nil {
+		return bucket, from, to, e.BadRequestError("invalid from date", err)
+	}
+	to, err = parseTimeParam(query.Get("to"), time.Now(), location)
+	if err != nil {
+		return bucket, from, to, e.BadRequestError("invalid to date", err)
+	}
+	if !from.Before(to) {
+		return bucket, from, to, e.BadRequestError("from date must be before to date", nil)
+	}
+	return bucket, from.In(location), to.In(location), nil
+}
+
+// Parse time from a query parameter given as RFC3339, date or unix seconds
+func parseTimeParam(value string, fallback time.Time, location *time.Location) (time.Time, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation(time.DateOnly, value, location)
+}
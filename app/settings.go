@@ -1,10 +1,13 @@
 package app
 
 import (
+	"dilogger/internal/alert"
 	"dilogger/internal/db"
+	"dilogger/internal/push"
 	"dilogger/internal/utils"
 	"io/fs"
 	"slices"
+	"time"
 
 	"github.com/pocketbase/pocketbase/core"
 )
@@ -14,11 +17,25 @@ func InitSettings(app core.App) {
 	settings := app.Settings()
 	settings.Meta.AppName = utils.GetEnv("PB_APP_NAME", "Price Logger")
 	settings.Meta.AppURL = utils.GetEnv("PB_APP_URL", "http://localhost:8090")
-	settings.Logs.MaxDays = 7
+	rules, err := db.ParseRetentionRules(utils.GetEnv("RETENTION_RULES", ""))
+	if err != nil {
+		app.Logger().Error(err.Error())
+	}
+	settings.Logs.MaxDays = db.RetentionDays(rules, "logs", 7)
+	if host := utils.GetEnv("SMTP_HOST", ""); host != "" {
+		settings.SMTP.Enabled = true
+		settings.SMTP.Host = host
+		settings.SMTP.Port = utils.GetEnvInt("SMTP_PORT", 587)
+		settings.SMTP.Username = utils.GetEnv("SMTP_USERNAME", "")
+		settings.SMTP.Password = utils.GetEnv("SMTP_PASSWORD", "")
+		settings.SMTP.TLS = utils.GetEnv("SMTP_TLS", "false") == "true"
+	}
+	settings.Meta.SenderName = utils.GetEnv("PB_APP_NAME", "Price Logger")
+	settings.Meta.SenderAddress = utils.GetEnv("PB_SENDER_ADDRESS", settings.Meta.SenderAddress)
 	settings.Logs.LogAuthId = false
 	settings.Logs.LogIP = false
 	settings.Logs.MinLevel = -4
-	err := app.Save(settings)
+	err = app.Save(settings)
 	if err != nil {
 		app.Logger().Error(err.Error())
 	}
@@ -27,31 +44,87 @@ func InitSettings(app core.App) {
 // Add all routes onServe trigger
 func AddRoutes(s *db.Server, htmlFS fs.FS, staticFS fs.FS) {
 	s.OnServe().BindFunc(func(se *core.ServeEvent) error {
+		s.InitCollections()
+		s.InitWebPush()
 		AddStopRoute(se)
 		AddUIRoute(se, htmlFS)
 		AddStaticRoute(se, staticFS)
 		AddReloadRoute(se, s)
+		AddHistoryRoute(se, s)
+		AddStatsRoute(se, s)
+		AddNotificationStatsRoute(se, s)
+		AddWebPushRoutes(se, s)
+		AddOneSignalRoutes(se, s)
+		AddBacktestRoute(se, s)
+		AddSavingsRoute(se, s)
+		AddBasketRoutes(se, s)
 		return se.Next()
 	})
 }
 
 // Add cron jobs
 func AddHourlyJob(s *db.Server, id string, Job func()) {
+	AddJob(s, id, "0 * * * *", Job)
+}
+
+// Add cron job with custom schedule
+func AddJob(s *db.Server, id string, schedule string, Job func()) {
 	cron := s.Cron()
-	cron.MustAdd(id, "0 * * * *", func() { Job() })
+	cron.MustAdd(id, schedule, func() { Job() })
 	cron.Start()
 }
 
 // Add monitoring functions
 func AddMonitor(s *db.Server) {
-	s.PriceUpdateHook(func(e *core.RecordEvent) error {
-		if s.CountPriceRecords(e.Record.GetString("product")) > 1 {
-			product := s.GetProduct(e.Record)
-			if product.Id != "" {
-				s.Notification.Send(product)
+	s.AlertRuleHook()
+	s.UserSettingsHook()
+	s.MessageTemplateHook()
+	s.PurchaseHook()
+	s.BasketBudgetHook()
+	// every price change, including a price revived by the update of its record, is alerted and checked against the purchases
+	s.PriceUpdateHook(func(record *core.Record) error {
+		product := s.GetProduct(record)
+		if product.Id == "" {
+			return nil
+		}
+		if s.CountPriceRecords(product.Id) > 1 {
+			event := push.Event{
+				Type:     push.PriceChange,
+				Product:  product,
+				OldPrice: s.PreviousPrice(record),
+				NewPrice: product.Price,
+			}
+			if event.IsDrop() {
+				event.DiscountQuality = s.DiscountQuality(product.Id, time.Now())
+			}
+			s.SendAlert(alert.Input{
+				Event:       event,
+				PreviousLow: s.PreviousLow(record),
+			})
+		}
+		s.CheckPriceProtection(product)
+		return nil
+	})
+	s.StockUpdateHook(func(record *core.Record, oldStock int) error {
+		eventType := ""
+		if oldStock <= 0 && record.GetInt("stock") > 0 {
+			eventType = push.BackInStock
+		} else if oldStock > 0 && record.GetInt("stock") <= 0 {
+			eventType = push.OutOfStock
+		}
+		if eventType != "" {
+			product, err := s.FindProduct(record.Id)
+			if err != nil {
+				return err
 			}
+			s.SendAlert(alert.Input{Event: push.Event{
+				Type:     eventType,
+				Product:  product,
+				OldPrice: product.Price,
+				NewPrice: product.Price,
+			}})
 		}
-		return e.Next()
+		return nil
 	})
 }
 
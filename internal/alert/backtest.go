@@ -0,0 +1,116 @@
+package alert
+
+import (
+	"dilogger/internal/model"
+	"dilogger/internal/push"
+	"dilogger/internal/series"
+	"slices"
+	"time"
+)
+
+// Stored history of a product replayed by a backtest
+type History struct {
+	Product model.Product
+	// Points are the price change points from the first observation, see series.Points
+	Points []model.PricePoint
+	// Stock is the daily stock of the product
+	Stock []model.StockPoint
+	// Discounts are the settings used to rate the price drops
+	Discounts series.DiscountRules
+}
+
+// Event on which a rule would have fired
+type Trigger struct {
+	Time     time.Time `json:"time"`
+	Product  string    `json:"product"`
+	Name     string    `json:"name"`
+	Event    string    `json:"event"`
+	OldPrice float64   `json:"old_price"`
+	NewPrice float64   `json:"new_price"`
+	Stock    int32     `json:"stock"`
+}
+
+// The `Backtest` function replays the price and stock changes between from and to through the rule
+// and returns the events on which it would have fired.
+// Like the live alerts, every price change is replayed, including the changes back to a price seen before.
+// The throttling of the live alerts is not replayed.
+func Backtest(rule Rule, history History, from, to time.Time) (triggers []Trigger, evaluated int) {
+	var inputs []Input
+	for i, point := range history.Points {
+		if i == 0 || point.Time.Before(from) || point.Time.After(to) {
+			continue
+		}
+		previous := history.Points[i-1]
+		if point.Price == previous.Price {
+			continue
+		}
+		inputs = append(inputs, Input{Event: push.Event{
+			Type:     push.PriceChange,
+			OldPrice: previous.Price,
+			NewPrice: point.Price,
+			Time:     point.Time,
+		}})
+	}
+	for i := 1; i < len(history.Stock); i++ {
+		previous, point := history.Stock[i-1], history.Stock[i]
+		if point.Time.Before(from) || point.Time.After(to) {
+			continue
+		}
+		eventType := ""
+		if previous.Stock <= 0 && point.Stock > 0 {
+			eventType = push.BackInStock
+		} else if previous.Stock > 0 && point.Stock <= 0 {
+			eventType = push.OutOfStock
+		}
+		if eventType != "" {
+			inputs = append(inputs, Input{Event: push.Event{Type: eventType, Time: point.Time}})
+		}
+	}
+	slices.SortStableFunc(inputs, func(a, b Input) int { return a.Event.Time.Compare(b.Event.Time) })
+
+	for _, input := range inputs {
+		event := &input.Event
+		event.Product = history.Product
+		event.Product.Stock = int32(history.StockAt(event.Time))
+		input.Stats = series.StatsAt(history.Points, event.Time)
+		if event.IsDrop() {
+			event.DiscountQuality = series.DiscountQuality(history.Points, event.Time, history.Discounts)
+		}
+		if event.Type != push.PriceChange {
+			event.OldPrice = input.Stats.Current
+			event.NewPrice = input.Stats.Current
+		}
+		for _, point := range history.Points {
+			if !point.Time.Before(event.Time) {
+				break
+			}
+			if input.PreviousLow == 0 || point.Price < input.PreviousLow {
+				input.PreviousLow = point.Price
+			}
+		}
+		if rule.Match(input) {
+			triggers = append(triggers, Trigger{
+				Time:     event.Time,
+				Product:  event.Product.Id,
+				Name:     event.Product.Name,
+				Event:    event.Type,
+				OldPrice: event.OldPrice,
+				NewPrice: event.NewPrice,
+				Stock:    event.Product.Stock,
+			})
+		}
+	}
+	return triggers, len(inputs)
+}
+
+// Stock of the product at time t, the current stock when no daily stock is stored yet
+func (h History) StockAt(t time.Time) int {
+	stock := int(h.Product.Stock)
+	for _, point := range h.Stock {
+		if point.Time.After(t) {
+			break
+		}
+		stock = point.Stock
+	}
+	return stock
+}
@@ -0,0 +1,48 @@
+package alert
+
+import (
+	"dilogger/internal/push"
+	"time"
+)
+
+// Time without a scrape after which a product is considered removed from the wishlists
+const removedAfter = 90 * time.Minute
+
+// The `DigestItem` function summarizes the history of a product between from and to, ok is false when nothing changed.
+// The product is removed when it was last seen during the period but not by the last scrape run at lastRun.
+func DigestItem(history History, from, to time.Time, lastRun time.Time) (item push.DigestItem, ok bool) {
+	item.Product = history.Product
+	var lowBefore, lowDuring, previous float64
+	var lastSeen time.Time
+	for _, point := range history.Points {
+		if point.Time.After(to) {
+			break
+		}
+		if point.Time.After(from) {
+			if previous != 0 && point.Price != previous {
+				item.Changes++
+			}
+			if lowDuring == 0 || point.Price < lowDuring {
+				lowDuring = point.Price
+			}
+		} else {
+			item.OldPrice = point.Price
+			if lowBefore == 0 || point.Price < lowBefore {
+				lowBefore = point.Price
+			}
+		}
+		item.NewPrice = point.Price
+		previous = point.Price
+		lastSeen = point.Time
+	}
+	if lastSeen.IsZero() {
+		return item, false
+	}
+	item.Added = history.Product.CreatedAt.After(from) && !history.Product.CreatedAt.After(to)
+	item.Removed = lastSeen.After(from) && lastRun.Sub(lastSeen) > removedAfter
+	item.AllTimeLow = lowBefore > 0 && lowDuring > 0 && lowDuring < lowBefore
+	item.OldStock = history.StockAt(from)
+	item.NewStock = history.StockAt(to)
+	stockChanged := (item.OldStock > 0) != (item.NewStock > 0)
+	return item, item.Added || item.Removed || item.AllTimeLow || item.OldPrice != item.NewPrice || stockChanged
+}
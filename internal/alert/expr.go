@@ -0,0 +1,434 @@
+package alert
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Types of the values of an expression
+type kind int
+
+const (
+	kindNumber kind = iota
+	kindString
+	kindBool
+)
+
+func (k kind) String() string {
+	return [...]string{"number", "text", "boolean"}[k]
+}
+
+// Variables available in the expressions and their types
+var variables = map[string]kind{
+	"event":            kindString,
+	"product":          kindString,
+	"name":             kindString,
+	"discount_quality": kindString,
+	"stock":            kindNumber,
+	"old_price":        kindNumber,
+	"new_price":        kindNumber,
+	"change":           kindNumber,
+	"change_percent":   kindNumber,
+	"drop_percent":     kindNumber,
+	"previous_low":     kindNumber,
+	"all_time_low":     kindNumber,
+	"all_time_high":    kindNumber,
+	"min_7d":           kindNumber,
+	"avg_7d":           kindNumber,
+	"min_30d":          kindNumber,
+	"avg_30d":          kindNumber,
+	"min_90d":          kindNumber,
+	"avg_90d":          kindNumber,
+	"changes":          kindNumber,
+	"at_30_day_low":    kindBool,
+}
+
+// Expression is a compiled alert condition like "new_price < 0.8 * avg_30d && stock > 0".
+// It follows the PocketBase filter syntax for comparisons, && and || and adds arithmetic with + - * /.
+type Expression struct {
+	source string
+	root   node
+}
+
+// The `Compile` function parses an expression and checks the types of its operands, the result must be a boolean.
+func Compile(source string) (*Expression, error) {
+	p := &parser{source: source}
+	if err := p.scan(); err != nil {
+		return nil, err
+	}
+	if len(p.tokens) == 1 {
+		return nil, fmt.Errorf("the expression is empty")
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, p.errorf(t, "unexpected '%s'", t.text)
+	}
+	if root.kind() != kindBool {
+		return nil, fmt.Errorf("the expression must be a condition, not a %s", root.kind())
+	}
+	return &Expression{source, root}, nil
+}
+
+// Evaluate the expression with the values of the variables
+func (e *Expression) Eval(vars map[string]any) bool {
+	return e.root.eval(vars).(bool)
+}
+
+// Source text of the expression
+func (e *Expression) String() string {
+	return e.source
+}
+
+// Node of the expression tree
+type node interface {
+	kind() kind
+	eval(vars map[string]any) any
+}
+
+type literal struct{ value any }
+
+func (n literal) kind() kind {
+	switch n.value.(type) {
+	case float64:
+		return kindNumber
+	case string:
+		return kindString
+	}
+	return kindBool
+}
+
+func (n literal) eval(map[string]any) any { return n.value }
+
+type variable struct {
+	name string
+	typ  kind
+}
+
+func (n variable) kind() kind { return n.typ }
+
+func (n variable) eval(vars map[string]any) any {
+	if value, ok := vars[n.name]; ok {
+		return value
+	}
+	return [...]any{0.0, "", false}[n.typ]
+}
+
+type unary struct {
+	op      string
+	operand node
+}
+
+func (n unary) kind() kind { return n.operand.kind() }
+
+func (n unary) eval(vars map[string]any) any {
+	if n.op == "!" {
+		return !n.operand.eval(vars).(bool)
+	}
+	return -n.operand.eval(vars).(float64)
+}
+
+type binary struct {
+	op          string
+	left, right node
+}
+
+func (n binary) kind() kind {
+	switch n.op {
+	case "+", "-", "*", "/":
+		return kindNumber
+	}
+	return kindBool
+}
+
+func (n binary) eval(vars map[string]any) any {
+	// && and || skip the right operand like in Go
+	switch n.op {
+	case "&&":
+		return n.left.eval(vars).(bool) && n.right.eval(vars).(bool)
+	case "||":
+		return n.left.eval(vars).(bool) || n.right.eval(vars).(bool)
+	}
+	left, right := n.left.eval(vars), n.right.eval(vars)
+	switch n.op {
+	case "=":
+		return left == right
+	case "!=":
+		return left != right
+	case "~":
+		return strings.Contains(strings.ToLower(left.(string)), strings.ToLower(right.(string)))
+	case "!~":
+		return !strings.Contains(strings.ToLower(left.(string)), strings.ToLower(right.(string)))
+	}
+	a, b := left.(float64), right.(float64)
+	switch n.op {
+	case "+":
+		return a + b
+	case "-":
+		return a - b
+	case "*":
+		return a * b
+	case "/":
+		return a / b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	}
+	return a >= b
+}
+
+// Tokens of the expressions
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenNumber
+	tokenText
+	tokenIdentifier
+	tokenOperator
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// Operators from the longest to the shortest so that "<=" is not read as "<"
+var operators = []string{"&&", "||", "!=", "!~", "<=", ">=", "==", "<", ">", "=", "~", "!", "+", "-", "*", "/", "(", ")"}
+
+// Recursive descent parser of the expressions
+type parser struct {
+	source string
+	tokens []token
+	next   int
+}
+
+// Split the source into tokens
+func (p *parser) scan() error {
+	runes := []rune(p.source)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case unicode.IsDigit(r) || r == '.':
+			start := i
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			p.tokens = append(p.tokens, token{tokenNumber, string(runes[start:i]), start})
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+			p.tokens = append(p.tokens, token{tokenIdentifier, string(runes[start:i]), start})
+		case r == '\'' || r == '"':
+			start := i
+			i++
+			for i < len(runes) && runes[i] != r {
+				i++
+			}
+			if i == len(runes) {
+				return fmt.Errorf("unterminated text at position %d", start+1)
+			}
+			i++
+			p.tokens = append(p.tokens, token{tokenText, string(runes[start+1 : i-1]), start})
+		default:
+			found := false
+			for _, op := range operators {
+				if strings.HasPrefix(string(runes[i:]), op) {
+					p.tokens = append(p.tokens, token{tokenOperator, op, i})
+					i += len([]rune(op))
+					found = true
+					break
+				}
+			}
+			if !found {
+				return fmt.Errorf("unexpected character '%c' at position %d", r, i+1)
+			}
+		}
+	}
+	p.tokens = append(p.tokens, token{tokenEOF, "end of expression", len(runes)})
+	return nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.next]
+}
+
+// Consume the next token when it is one of the operators
+func (p *parser) accept(ops ...string) (token, bool) {
+	t := p.peek()
+	if t.kind != tokenOperator {
+		return t, false
+	}
+	for _, op := range ops {
+		if t.text == op {
+			p.next++
+			return t, true
+		}
+	}
+	return t, false
+}
+
+func (p *parser) errorf(t token, format string, args ...any) error {
+	return fmt.Errorf(format+" at position %d", append(args, t.pos+1)...)
+}
+
+// Check that both operands of an operator have the expected type
+func (p *parser) expect(t token, want kind, operands ...node) error {
+	for _, operand := range operands {
+		if operand.kind() != want {
+			return p.errorf(t, "'%s' expects %s operands, got %s", t.text, want, operand.kind())
+		}
+	}
+	return nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	return p.parseJoin(p.parseAnd, kindBool, "||")
+}
+
+func (p *parser) parseAnd() (node, error) {
+	return p.parseJoin(p.parseNot, kindBool, "&&")
+}
+
+func (p *parser) parseSum() (node, error) {
+	return p.parseJoin(p.parseProduct, kindNumber, "+", "-")
+}
+
+func (p *parser) parseProduct() (node, error) {
+	return p.parseJoin(p.parseUnary, kindNumber, "*", "/")
+}
+
+// Parse left associative operators sharing the same precedence and operand type
+func (p *parser) parseJoin(operand func() (node, error), want kind, ops ...string) (node, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t, ok := p.accept(ops...)
+		if !ok {
+			return left, nil
+		}
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(t, want, left, right); err != nil {
+			return nil, err
+		}
+		left = binary{t.text, left, right}
+	}
+}
+
+func (p *parser) parseNot() (node, error) {
+	if t, ok := p.accept("!"); ok {
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(t, kindBool, operand); err != nil {
+			return nil, err
+		}
+		return unary{"!", operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	t, ok := p.accept("=", "==", "!=", "<", "<=", ">", ">=", "~", "!~")
+	if !ok {
+		return left, nil
+	}
+	right, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	op := t.text
+	switch op {
+	case "==":
+		op = "="
+		fallthrough
+	case "=", "!=":
+		if left.kind() != right.kind() {
+			return nil, p.errorf(t, "cannot compare %s with %s", left.kind(), right.kind())
+		}
+	case "~", "!~":
+		err = p.expect(t, kindString, left, right)
+	default:
+		err = p.expect(t, kindNumber, left, right)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return binary{op, left, right}, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if t, ok := p.accept("-"); ok {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(t, kindNumber, operand); err != nil {
+			return nil, err
+		}
+		return unary{"-", operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.peek()
+	p.next++
+	switch t.kind {
+	case tokenNumber:
+		value, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, p.errorf(t, "invalid number '%s'", t.text)
+		}
+		return literal{value}, nil
+	case tokenText:
+		return literal{t.text}, nil
+	case tokenIdentifier:
+		switch t.text {
+		case "true":
+			return literal{true}, nil
+		case "false":
+			return literal{false}, nil
+		}
+		typ, ok := variables[t.text]
+		if !ok {
+			return nil, p.errorf(t, "unknown variable '%s'", t.text)
+		}
+		return variable{t.text, typ}, nil
+	case tokenOperator:
+		if t.text == "(" {
+			inner, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if closing, ok := p.accept(")"); !ok {
+				return nil, p.errorf(closing, "expected ')' but found '%s'", closing.text)
+			}
+			return inner, nil
+		}
+	}
+	p.next--
+	return nil, p.errorf(t, "unexpected '%s'", t.text)
+}
@@ -0,0 +1,106 @@
+package alert
+
+import "testing"
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		err    string
+	}{
+		{"", "the expression is empty"},
+		{"   ", "the expression is empty"},
+		{"new_price", "the expression must be a condition, not a number"},
+		{"name", "the expression must be a condition, not a text"},
+		{"price < 10", "unknown variable 'price' at position 1"},
+		{"new_price < 'cheap'", "'<' expects number operands, got text at position 11"},
+		{"name = 10", "cannot compare text with number at position 6"},
+		{"name ~ 10", "'~' expects text operands, got number at position 6"},
+		{"new_price && true", "'&&' expects boolean operands, got number at position 11"},
+		{"!new_price", "'!' expects boolean operands, got number at position 1"},
+		{"-at_30_day_low", "'-' expects number operands, got boolean at position 1"},
+		{"name = 'open", "unterminated text at position 8"},
+		{"new_price < 10 $", "unexpected character '$' at position 16"},
+		{"(new_price < 10", "expected ')' but found 'end of expression' at position 16"},
+		{"new_price < 10 10", "unexpected '10' at position 16"},
+		{"new_price <", "unexpected 'end of expression' at position 12"},
+		{"new_price < 1.2.3", "invalid number '1.2.3' at position 13"},
+	}
+	for _, test := range tests {
+		_, err := Compile(test.source)
+		if err == nil || err.Error() != test.err {
+			t.Errorf("Compile(%q) error = %v, want %q", test.source, err, test.err)
+		}
+	}
+}
+
+func TestEval(t *testing.T) {
+	vars := map[string]any{
+		"event":          "price_change",
+		"name":           "Blue Kettle",
+		"stock":          3.0,
+		"old_price":      100.0,
+		"new_price":      80.0,
+		"drop_percent":   20.0,
+		"avg_30d":        95.0,
+		"at_30_day_low":  true,
+		"change_percent": -20.0,
+	}
+	tests := []struct {
+		source string
+		want   bool
+	}{
+		{"new_price < 0.9 * avg_30d", true},
+		{"new_price < 0.8 * avg_30d", false},
+		{"old_price - new_price = 20", true},
+		{"old_price - new_price - 10 = 10", true},
+		{"old_price / 4 / 5 = 5", true},
+		{"2 + 3 * 4 = 14", true},
+		{"(2 + 3) * 4 = 20", true},
+		{"-change_percent = drop_percent", true},
+		{"- -1 = 1", true},
+		{"drop_percent >= 20 && stock > 0", true},
+		{"drop_percent > 20 || stock > 0", true},
+		{"drop_percent > 20 || stock > 5", false},
+		{"!at_30_day_low", false},
+		{"!(stock > 5)", true},
+		{"at_30_day_low = true", true},
+		{"at_30_day_low != false", true},
+		{"event = 'price_change'", true},
+		{`event == "back_in_stock"`, false},
+		{"name ~ 'kettle'", true},
+		{"name !~ 'KETTLE'", false},
+		{"discount_quality = ''", true},
+		{"min_90d = 0", true},
+		{"true || stock / 0 > 1", true},
+		{"false && name ~ 'x'", false},
+	}
+	for _, test := range tests {
+		expression, err := Compile(test.source)
+		if err != nil {
+			t.Errorf("Compile(%q) error = %v", test.source, err)
+			continue
+		}
+		if got := expression.Eval(vars); got != test.want {
+			t.Errorf("Eval(%q) = %v, want %v", test.source, got, test.want)
+		}
+		if expression.String() != test.source {
+			t.Errorf("String() = %q, want %q", expression.String(), test.source)
+		}
+	}
+}
+
+func TestCompilePrecedence(t *testing.T) {
+	// && binds tighter than ||, so the right side alone decides when the left is false
+	expression, err := Compile("stock > 5 && stock < 10 || name ~ 'a'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]bool{"a": true, "b": false} {
+		if got := expression.Eval(map[string]any{"stock": 1.0, "name": name}); got != want {
+			t.Errorf("Eval(name=%q) = %v, want %v", name, got, want)
+		}
+	}
+	if _, err := Compile("(((stock > 1)))"); err != nil {
+		t.Errorf("nested parentheses: %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+package alert
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Quiet hours of a user in the user's timezone, the end is on the next day when it is before the start
+type QuietHours struct {
+	Start    time.Duration
+	End      time.Duration
+	Location *time.Location
+}
+
+// The `ParseQuietHours` function parses quiet hours written as "22:00-07:00" in the IANA timezone, like Asia/Kolkata.
+// An empty value returns nil and an empty timezone uses the local timezone of the server.
+func ParseQuietHours(value string, timezone string) (*QuietHours, error) {
+	location, err := LoadLocation(timezone)
+	if err != nil {
+		return nil, err
+	}
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+	start, end, found := strings.Cut(value, "-")
+	if !found {
+		return nil, fmt.Errorf("invalid quiet hours '%s': expected HH:MM-HH:MM", value)
+	}
+	q := &QuietHours{Location: location}
+	if q.Start, err = parseClock(start); err != nil {
+		return nil, err
+	}
+	if q.End, err = parseClock(end); err != nil {
+		return nil, err
+	}
+	if q.Start == q.End {
+		return nil, fmt.Errorf("invalid quiet hours '%s': the start and end are the same", value)
+	}
+	return q, nil
+}
+
+// The `LoadLocation` function loads an IANA timezone like Asia/Kolkata, an empty timezone is the local timezone of the server.
+func LoadLocation(timezone string) (*time.Location, error) {
+	if timezone == "" {
+		return time.Local, nil
+	}
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, fmt.Errorf("unknown timezone '%s'", timezone)
+	}
+	return location, nil
+}
+
+// Parse a time of the day written as HH:MM
+func parseClock(value string) (time.Duration, error) {
+	clock, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time '%s': expected HH:MM", strings.TrimSpace(value))
+	}
+	return time.Duration(clock.Hour())*time.Hour + time.Duration(clock.Minute())*time.Minute, nil
+}
+
+// Check whether the time is inside the quiet hours
+func (q *QuietHours) Contains(t time.Time) bool {
+	if q == nil {
+		return false
+	}
+	local := t.In(q.Location)
+	clock := time.Duration(local.Hour())*time.Hour + time.Duration(local.Minute())*time.Minute
+	if q.Start < q.End {
+		return clock >= q.Start && clock < q.End
+	}
+	return clock >= q.Start || clock < q.End
+}
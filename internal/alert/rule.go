@@ -0,0 +1,159 @@
+package alert
+
+import (
+	"dilogger/internal/model"
+	"dilogger/internal/push"
+	"slices"
+)
+
+// Conditions of an alert rule
+const (
+	BelowTarget = "below_target"
+	DropPercent = "drop_percent"
+	AllTimeLow  = "all_time_low"
+	BackInStock = "back_in_stock"
+	OutOfStock  = "out_of_stock"
+	Listed      = "listed"
+	Delisted    = "delisted"
+	Custom      = "expression"
+)
+
+// Conditions supported by the alert rules
+var Conditions = []string{BelowTarget, DropPercent, AllTimeLow, BackInStock, OutOfStock, Listed, Delisted, Custom}
+
+// Alert rule of a user, an empty product matches every product
+type Rule struct {
+	Id         string   `json:"id"`
+	User       string   `json:"user"`
+	Product    string   `json:"product"`
+	Condition  string   `json:"condition"`
+	Threshold  float64  `json:"threshold"`
+	Expression string   `json:"expression"`
+	Channels   []string `json:"channels"`
+}
+
+// Invalid field of an alert rule
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+func (e *FieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// Input of the evaluation of an event
+type Input struct {
+	Event push.Event
+	// PreviousLow is the lowest price of the product before the event, zero when there is none
+	PreviousLow float64
+	// Stats of the product including the new price, only needed by the expressions
+	Stats model.ProductStats
+}
+
+// The `Vars` function returns the values of the expression variables for the input.
+func (input Input) Vars() map[string]any {
+	event, stats := input.Event, input.Stats
+	return map[string]any{
+		"event":            event.Type,
+		"product":          event.Product.Id,
+		"name":             event.Product.Name,
+		"discount_quality": event.DiscountQuality,
+		"stock":            float64(event.Product.Stock),
+		"old_price":        event.OldPrice,
+		"new_price":        event.NewPrice,
+		"change":           event.NewPrice - event.OldPrice,
+		"change_percent":   event.ChangePercent(),
+		"drop_percent":     max(-event.ChangePercent(), 0),
+		"previous_low":     input.PreviousLow,
+		"all_time_low":     stats.AllTimeLow.Price,
+		"all_time_high":    stats.AllTimeHigh.Price,
+		"min_7d":           stats.Last7Days.Min,
+		"avg_7d":           stats.Last7Days.Avg,
+		"min_30d":          stats.Last30Days.Min,
+		"avg_30d":          stats.Last30Days.Avg,
+		"min_90d":          stats.Last90Days.Min,
+		"avg_90d":          stats.Last90Days.Avg,
+		"changes":          float64(stats.Changes),
+		"at_30_day_low":    stats.At30DayLow,
+	}
+}
+
+// The `ValidateCondition` function checks that the threshold or expression suits the condition of the rule.
+func (r Rule) ValidateCondition() error {
+	switch r.Condition {
+	case BelowTarget:
+		if r.Threshold <= 0 {
+			return &FieldError{"threshold", "the target price must be greater than 0"}
+		}
+	case DropPercent:
+		if r.Threshold <= 0 || r.Threshold >= 100 {
+			return &FieldError{"threshold", "the drop percent must be between 0 and 100"}
+		}
+	case Custom:
+		if _, err := Compile(r.Expression); err != nil {
+			return &FieldError{"expression", err.Error()}
+		}
+	case AllTimeLow, BackInStock, OutOfStock, Listed, Delisted:
+	default:
+		return &FieldError{"condition", "unknown condition " + r.Condition}
+	}
+	return nil
+}
+
+// The `Validate` function checks the condition and the channels of the rule.
+func (r Rule) Validate() error {
+	if err := r.ValidateCondition(); err != nil {
+		return err
+	}
+	if len(r.Channels) == 0 {
+		return &FieldError{"channels", "at least one channel is required"}
+	}
+	return nil
+}
+
+// Check whether the rule matches the event
+func (r Rule) Match(input Input) bool {
+	event := input.Event
+	if r.Product != "" && r.Product != event.Product.Id {
+		return false
+	}
+	switch r.Condition {
+	case BelowTarget:
+		// only the crossing of the target is notified, not every change below it
+		return event.Type == push.PriceChange && event.NewPrice <= r.Threshold &&
+			(event.OldPrice == 0 || event.OldPrice > r.Threshold)
+	case DropPercent:
+		return event.IsDrop() && -event.ChangePercent() >= r.Threshold
+	case AllTimeLow:
+		return event.Type == push.PriceChange && input.PreviousLow > 0 && event.NewPrice < input.PreviousLow
+	case BackInStock:
+		return event.Type == push.BackInStock
+	case OutOfStock:
+		return event.Type == push.OutOfStock
+	case Listed:
+		return event.Type == push.Listed
+	case Delisted:
+		return event.Type == push.Delisted
+	case Custom:
+		expression, err := Compile(r.Expression)
+		return err == nil && expression.Eval(input.Vars())
+	}
+	return false
+}
+
+// The `Evaluate` function matches the rules against the event and returns the users to notify on each channel.
+func Evaluate(rules []Rule, input Input) map[string][]string {
+	targets := map[string][]string{}
+	for _, rule := range rules {
+		if !rule.Match(input) {
+			continue
+		}
+		for _, channel := range rule.Channels {
+			if !slices.Contains(targets[channel], rule.User) {
+				targets[channel] = append(targets[channel], rule.User)
+			}
+		}
+	}
+	return targets
+}
@@ -0,0 +1,65 @@
+package alert
+
+import (
+	"cmp"
+	"dilogger/internal/push"
+	"slices"
+	"strings"
+)
+
+// Reasons of a detected sale
+const (
+	SaleProducts = "products"
+	SaleUrlShare = "url_share"
+)
+
+// Reasons which can be stored in the sale events
+var SaleReasons = []string{SaleProducts, SaleUrlShare}
+
+// Thresholds of the sale detection, a zero threshold is disabled
+type SaleThresholds struct {
+	// MinProducts is the number of products dropping in the same run which makes a sale
+	MinProducts int
+	// MinPercent is the share of the products of a URL dropping in the same run which makes a sale
+	MinPercent float64
+}
+
+// Products scraped from a URL during a run and how many of them dropped
+type UrlDrops struct {
+	Url      string `json:"url"`
+	Products int    `json:"products"`
+	Drops    int    `json:"drops"`
+}
+
+// Share of the products of the URL which dropped, in percent
+func (u UrlDrops) Percent() float64 {
+	if u.Products == 0 {
+		return 0
+	}
+	return float64(u.Drops) / float64(u.Products) * 100
+}
+
+// The `DetectSale` function checks whether the price drops of a scrape run are correlated, products lists the ids of the products scraped from each URL.
+// It returns the reason of the sale, empty when there is none, and the drops of every URL.
+// A URL only makes a sale with at least two drops, so a single product does not.
+func DetectSale(drops []push.Event, products map[string][]string, thresholds SaleThresholds) (reason string, urls []UrlDrops) {
+	for url, ids := range products {
+		stats := UrlDrops{Url: url, Products: len(ids)}
+		for _, drop := range drops {
+			if slices.Contains(ids, drop.Product.Id) {
+				stats.Drops++
+			}
+		}
+		urls = append(urls, stats)
+		if thresholds.MinPercent > 0 && stats.Drops >= 2 && stats.Percent() >= thresholds.MinPercent {
+			reason = SaleUrlShare
+		}
+	}
+	slices.SortFunc(urls, func(a, b UrlDrops) int {
+		return cmp.Or(cmp.Compare(b.Drops, a.Drops), strings.Compare(a.Url, b.Url))
+	})
+	if thresholds.MinProducts > 0 && len(drops) >= thresholds.MinProducts {
+		reason = SaleProducts
+	}
+	return reason, urls
+}
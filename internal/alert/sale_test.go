@@ -0,0 +1,61 @@
+package alert
+
+import (
+	"dilogger/internal/model"
+	"dilogger/internal/push"
+	"slices"
+	"testing"
+)
+
+func TestDetectSale(t *testing.T) {
+	drops := func(ids ...string) []push.Event {
+		var events []push.Event
+		for _, id := range ids {
+			events = append(events, push.Event{Type: push.PriceChange, Product: model.Product{Id: id}, OldPrice: 100, NewPrice: 80})
+		}
+		return events
+	}
+	products := map[string][]string{
+		"https://a.io/list": {"a1", "a2", "a3", "a4"},
+		"https://b.io/list": {"b1", "b2"},
+		"https://c.io/list": {"c1"},
+	}
+	tests := []struct {
+		name       string
+		drops      []push.Event
+		thresholds SaleThresholds
+		reason     string
+		urls       []UrlDrops
+	}{
+		{"no drops", nil, SaleThresholds{MinProducts: 3, MinPercent: 50}, "", []UrlDrops{
+			{"https://a.io/list", 4, 0}, {"https://b.io/list", 2, 0}, {"https://c.io/list", 1, 0},
+		}},
+		{"too few drops", drops("a1", "b1"), SaleThresholds{MinProducts: 3, MinPercent: 60}, "", []UrlDrops{
+			{"https://a.io/list", 4, 1}, {"https://b.io/list", 2, 1}, {"https://c.io/list", 1, 0},
+		}},
+		{"enough products", drops("a1", "b1", "c1"), SaleThresholds{MinProducts: 3}, SaleProducts, []UrlDrops{
+			{"https://a.io/list", 4, 1}, {"https://b.io/list", 2, 1}, {"https://c.io/list", 1, 1},
+		}},
+		{"share of a URL", drops("a1", "a2"), SaleThresholds{MinProducts: 3, MinPercent: 50}, SaleUrlShare, []UrlDrops{
+			{"https://a.io/list", 4, 2}, {"https://b.io/list", 2, 0}, {"https://c.io/list", 1, 0},
+		}},
+		{"single product URL", drops("c1"), SaleThresholds{MinPercent: 50}, "", []UrlDrops{
+			{"https://c.io/list", 1, 1}, {"https://a.io/list", 4, 0}, {"https://b.io/list", 2, 0},
+		}},
+		{"products win over share", drops("b1", "b2", "a1"), SaleThresholds{MinProducts: 3, MinPercent: 50}, SaleProducts, []UrlDrops{
+			{"https://b.io/list", 2, 2}, {"https://a.io/list", 4, 1}, {"https://c.io/list", 1, 0},
+		}},
+		{"disabled thresholds", drops("a1", "a2", "a3", "a4"), SaleThresholds{}, "", []UrlDrops{
+			{"https://a.io/list", 4, 4}, {"https://b.io/list", 2, 0}, {"https://c.io/list", 1, 0},
+		}},
+	}
+	for _, test := range tests {
+		reason, urls := DetectSale(test.drops, products, test.thresholds)
+		if reason != test.reason {
+			t.Errorf("%s: reason = %q, want %q", test.name, reason, test.reason)
+		}
+		if !slices.Equal(urls, test.urls) {
+			t.Errorf("%s: urls = %v, want %v", test.name, urls, test.urls)
+		}
+	}
+}
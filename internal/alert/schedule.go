@@ -0,0 +1,73 @@
+package alert
+
+import (
+	"dilogger/internal/push"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Days of the week accepted by the weekly digests
+var Weekdays = []string{"sunday", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday"}
+
+// Schedule of the digests of a user, sent every day or every week on Weekday at the time of the day Clock
+type Schedule struct {
+	Period   string
+	Clock    time.Duration
+	Weekday  time.Weekday
+	Location *time.Location
+}
+
+// The `ParseSchedule` function parses a daily or weekly digest schedule, an empty period returns nil once the other values are checked.
+// The time of the day defaults to 08:00, the weekday to monday and the timezone to the local timezone of the server.
+func ParseSchedule(period string, clock string, weekday string, timezone string) (*Schedule, error) {
+	location, err := LoadLocation(timezone)
+	if err != nil {
+		return nil, err
+	}
+	s := &Schedule{Period: period, Clock: 8 * time.Hour, Weekday: time.Monday, Location: location}
+	if strings.TrimSpace(clock) != "" {
+		if s.Clock, err = parseClock(clock); err != nil {
+			return nil, err
+		}
+	}
+	if weekday != "" {
+		found := false
+		for i, name := range Weekdays {
+			if strings.EqualFold(name, weekday) {
+				s.Weekday, found = time.Weekday(i), true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("invalid weekday '%s'", weekday)
+		}
+	}
+	switch period {
+	case "":
+		return nil, nil
+	case push.Daily, push.Weekly:
+		return s, nil
+	}
+	return nil, fmt.Errorf("invalid digest period '%s': expected %s or %s", period, push.Daily, push.Weekly)
+}
+
+// The `Last` function returns the latest scheduled time which is not after t.
+func (s *Schedule) Last(t time.Time) time.Time {
+	local := t.In(s.Location)
+	day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, s.Location)
+	for {
+		scheduled := time.Date(day.Year(), day.Month(), day.Day(), int(s.Clock.Hours()), int(s.Clock.Minutes())%60, 0, 0, s.Location)
+		if !scheduled.After(t) && (s.Period == push.Daily || scheduled.Weekday() == s.Weekday) {
+			return scheduled
+		}
+		day = day.AddDate(0, 0, -1)
+	}
+}
+
+// Start of the period of the digest sent at the scheduled time
+func (s *Schedule) Start(scheduled time.Time) time.Time {
+	if s.Period == push.Weekly {
+		return scheduled.AddDate(0, 0, -7)
+	}
+	return scheduled.AddDate(0, 0, -1)
+}
@@ -0,0 +1,74 @@
+package alert
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleLast(t *testing.T) {
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		period  string
+		clock   string
+		weekday string
+		zone    string
+		t       time.Time
+		want    time.Time
+	}{
+		{"daily after the time", "daily", "08:00", "", "Asia/Kolkata",
+			time.Date(2025, 3, 12, 9, 0, 0, 0, kolkata), time.Date(2025, 3, 12, 8, 0, 0, 0, kolkata)},
+		{"daily before the time", "daily", "08:00", "", "Asia/Kolkata",
+			time.Date(2025, 3, 12, 7, 59, 0, 0, kolkata), time.Date(2025, 3, 11, 8, 0, 0, 0, kolkata)},
+		{"daily at the time", "daily", "20:30", "", "Asia/Kolkata",
+			time.Date(2025, 3, 12, 20, 30, 0, 0, kolkata), time.Date(2025, 3, 12, 20, 30, 0, 0, kolkata)},
+		{"daily across the year", "daily", "", "", "Asia/Kolkata",
+			time.Date(2025, 1, 1, 6, 0, 0, 0, kolkata), time.Date(2024, 12, 31, 8, 0, 0, 0, kolkata)},
+		{"daily in another timezone", "daily", "08:00", "", "Asia/Kolkata",
+			time.Date(2025, 3, 12, 1, 0, 0, 0, time.UTC), time.Date(2025, 3, 11, 8, 0, 0, 0, kolkata)},
+		{"weekly on the weekday", "weekly", "08:00", "wednesday", "Asia/Kolkata",
+			time.Date(2025, 3, 12, 9, 0, 0, 0, kolkata), time.Date(2025, 3, 12, 8, 0, 0, 0, kolkata)},
+		{"weekly before the time on the weekday", "weekly", "08:00", "wednesday", "Asia/Kolkata",
+			time.Date(2025, 3, 12, 7, 0, 0, 0, kolkata), time.Date(2025, 3, 5, 8, 0, 0, 0, kolkata)},
+		{"weekly default monday", "weekly", "", "", "Asia/Kolkata",
+			time.Date(2025, 3, 16, 12, 0, 0, 0, kolkata), time.Date(2025, 3, 10, 8, 0, 0, 0, kolkata)},
+		{"daily after a daylight saving change", "daily", "08:00", "", "America/New_York",
+			time.Date(2025, 3, 9, 9, 0, 0, 0, newYork), time.Date(2025, 3, 9, 8, 0, 0, 0, newYork)},
+		{"weekly across a daylight saving change", "weekly", "08:00", "sunday", "America/New_York",
+			time.Date(2025, 3, 15, 9, 0, 0, 0, newYork), time.Date(2025, 3, 9, 8, 0, 0, 0, newYork)},
+	}
+	for _, test := range tests {
+		schedule, err := ParseSchedule(test.period, test.clock, test.weekday, test.zone)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if got := schedule.Last(test.t); !got.Equal(test.want) {
+			t.Errorf("%s: Last(%v) = %v, want %v", test.name, test.t, got, test.want)
+		}
+	}
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	tests := []struct {
+		period, clock, weekday, zone string
+	}{
+		{"hourly", "", "", ""},
+		{"daily", "25:00", "", ""},
+		{"weekly", "", "someday", ""},
+		{"daily", "", "", "Mars/Olympus"},
+		// the other values are checked without period too
+		{"", "", "someday", ""},
+	}
+	for _, test := range tests {
+		if _, err := ParseSchedule(test.period, test.clock, test.weekday, test.zone); err == nil {
+			t.Errorf("ParseSchedule(%q, %q, %q, %q) succeeded, want an error", test.period, test.clock, test.weekday, test.zone)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package db
+
+import (
+	"dilogger/internal/alert"
+	"dilogger/internal/push"
+	"dilogger/internal/series"
+	"errors"
+	"slices"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Channels which can be selected in the alert rules
+var AlertChannels = []string{"email", "webpush", "onesignal", "telegram", "discord", "slack", "ntfy", "gotify", "webhook"}
+
+// Create new alert rule collection in database, adding the fields missing from older versions
+func (s *Server) NewAlertRuleCollection() {
+	if collection, err := s.App.FindCollectionByNameOrId("alert_rules"); err == nil {
+		s.upgradeAlertRuleCollection(collection)
+		return
+	}
+	users, err := s.App.FindCollectionByNameOrId("users")
+	if err != nil {
+		s.logger.Error(err.Error())
+		return
+	}
+	products, err := s.App.FindCollectionByNameOrId("products")
+	if err != nil {
+		s.logger.Error(err.Error())
+		return
+	}
+	collection := NewCollection("alert_rules", users.Id, products.Id, alert.Conditions, AlertChannels)
+	if err := s.App.Save(collection); err != nil {
+		s.logger.Error(err.Error())
+	}
+}
+
+// Add the conditions and the expression field to an alert rule collection created before they existed
+func (s *Server) upgradeAlertRuleCollection(collection *core.Collection) {
+	condition, ok := collection.Fields.GetByName("condition").(*core.SelectField)
+	if !ok {
+		return
+	}
+	_, hasExpression := collection.Fields.GetByName("expression").(*core.TextField)
+	if hasExpression && !slices.ContainsFunc(alert.Conditions, func(value string) bool { return !slices.Contains(condition.Values, value) }) {
+		return
+	}
+	condition.Values = alert.Conditions
+	if !hasExpression {
+		collection.Fields.Add(&core.TextField{
+			Name: "expression",
+			Max:  1000,
+		})
+	}
+	if err := s.App.Save(collection); err != nil {
+		s.logger.Error(err.Error())
+	}
+}
+
+// Validate the threshold and channels of alert rules when they are saved, rules created through the API are active unless the request sets `active`.
+// A rule on a shared channel gates the channel for every user, so only superusers may save one.
+func (s *Server) AlertRuleHook() {
+	s.App.OnRecordCreateRequest("alert_rules").BindFunc(func(e *core.RecordRequestEvent) error {
+		info, err := e.RequestInfo()
+		if err != nil {
+			return err
+		}
+		if _, ok := info.Body["active"]; !ok {
+			e.Record.Set("active", true)
+		}
+		if err := sharedChannelAccess(e); err != nil {
+			return err
+		}
+		return e.Next()
+	})
+	s.App.OnRecordUpdateRequest("alert_rules").BindFunc(func(e *core.RecordRequestEvent) error {
+		if err := sharedChannelAccess(e); err != nil {
+			return err
+		}
+		return e.Next()
+	})
+	s.App.OnRecordValidate("alert_rules").BindFunc(func(e *core.RecordEvent) error {
+		if err := alertRule(e.Record).Validate(); err != nil {
+			var fieldErr *alert.FieldError
+			if errors.As(err, &fieldErr) {
+				return validation.Errors{fieldErr.Field: validation.NewError("validation_invalid_"+fieldErr.Field, fieldErr.Message)}
+			}
+			return err
+		}
+		return e.Next()
+	})
+}
+
+// Refuse an alert rule selecting a shared channel unless the request is made by a superuser
+func sharedChannelAccess(e *core.RecordRequestEvent) error {
+	if e.HasSuperuserAuth() {
+		return nil
+	}
+	for _, channel := range e.Record.GetStringSlice("channels") {
+		if !slices.Contains(UserChannels, channel) {
+			return e.ForbiddenError("only superusers can select the shared channel "+channel, nil)
+		}
+	}
+	return nil
+}
+
+// Create alert rule from its record
+func alertRule(record *core.Record) alert.Rule {
+	return alert.Rule{
+		Id:         record.Id,
+		User:       record.GetString("user"),
+		Product:    record.GetString("product"),
+		Condition:  record.GetString("condition"),
+		Threshold:  record.GetFloat("threshold"),
+		Expression: record.GetString("expression"),
+		Channels:   record.GetStringSlice("channels"),
+	}
+}
+
+// Get the active alert rules of a product, including the rules of every product
+func (s *Server) AlertRules(productId string) ([]alert.Rule, error) {
+	records, err := s.App.FindAllRecords("alert_rules", dbx.HashExp{"active": true}, dbx.Or(
+		dbx.HashExp{"product": productId},
+		dbx.HashExp{"product": ""},
+	))
+	if err != nil {
+		return nil, err
+	}
+	rules := make([]alert.Rule, len(records))
+	for i, record := range records {
+		rules[i] = alertRule(record)
+	}
+	return rules, nil
+}
+
+// Get the lowest price of the product before the given price record
+func (s *Server) PreviousLow(priceRecord *core.Record) float64 {
+	var low float64
+	err := s.App.DB().
+		Select("COALESCE(MIN(price), 0)").
+		From("prices").
+		Where(dbx.HashExp{"product": priceRecord.GetString("product")}).
+		AndWhere(dbx.Not(dbx.HashExp{"id": priceRecord.Id})).
+		Row(&low)
+	if err != nil {
+		s.logger.Error(err.Error())
+	}
+	return low
+}
+
+// The `SendAlert` function notifies the users whose alert rules match the event, unless it is throttled.
+// A channel selected by no active alert rule receives every event, see alertTargets.
+// The price drops of a scrape run are collected until the end of the run to detect sales.
+func (s *Server) SendAlert(input alert.Input) {
+	if input.Event.IsDrop() && s.collectDrop(input) {
+		return
+	}
+	event, ok := s.alertTargets(input)
+	if !ok {
+		return
+	}
+	event, ok = s.Throttle(event)
+	if !ok {
+		return
+	}
+	s.Notification.Send(event)
+	s.LogSentNotification(event)
+}
+
+// The `alertTargets` function returns the event with the users whose alert rules match it, ok is false when nobody is targeted.
+// The rules only gate the channels they select: the other shared channels receive every event,
+// and on the user channels the users without a rule on the channel receive every event too.
+// The targets are nil while no user has an active alert rule.
+func (s *Server) alertTargets(input alert.Input) (push.Event, bool) {
+	ruled, err := s.ruledChannels()
+	if err != nil {
+		s.logger.Error(err.Error())
+		return input.Event, false
+	}
+	if len(ruled) == 0 {
+		return input.Event, true
+	}
+	rules, err := s.AlertRules(input.Event.Product.Id)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return input.Event, false
+	}
+	if slices.ContainsFunc(rules, func(rule alert.Rule) bool { return rule.Condition == alert.Custom }) {
+		// the statistics are computed like in the backtests
+		t := input.Event.Time
+		if t.IsZero() {
+			t = time.Now()
+		}
+		history, err := s.ProductHistory(input.Event.Product.Id, t)
+		if err != nil {
+			s.logger.Error(err.Error())
+		} else {
+			input.Stats = series.StatsAt(history.Points, t)
+		}
+	}
+	matched := alert.Evaluate(rules, input)
+	targets := map[string][]string{}
+	for _, channel := range AlertChannels {
+		users := matched[channel]
+		if !ruled[channel] || slices.Contains(UserChannels, channel) {
+			users = append(users, push.AllSubscribers)
+		}
+		if len(users) > 0 {
+			targets[channel] = users
+		}
+	}
+	if len(targets) == 0 {
+		s.logger.Debug("no alert rule matched", "event", input.Event.Type, "product", input.Event.Product.Id)
+		return input.Event, false
+	}
+	input.Event.Targets = targets
+	return input.Event, true
+}
+
+// Get the channels selected by at least one active alert rule
+func (s *Server) ruledChannels() (map[string]bool, error) {
+	var channels []string
+	err := s.App.DB().
+		Select("c.value").
+		Distinct(true).
+		From("alert_rules r").
+		InnerJoin("json_each(r.channels) c", nil).
+		Where(dbx.HashExp{"r.active": true}).
+		Column(&channels)
+	if err != nil {
+		return nil, err
+	}
+	ruled := map[string]bool{}
+	for _, channel := range channels {
+		ruled[channel] = true
+	}
+	return ruled, nil
+}
+
+// Expression matching the records whose user, in the given column, has no active alert rule on the channel
+func withoutAlertRule(column string, channel string) dbx.Expression {
+	return dbx.NewExp(
+		"NOT EXISTS (SELECT 1 FROM alert_rules r, json_each(r.channels) c WHERE r.user = "+column+" AND r.active = TRUE AND c.value = {:channel})",
+		dbx.Params{"channel": channel},
+	)
+}
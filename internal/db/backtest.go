@@ -0,0 +1,91 @@
+package db
+
+import (
+	"dilogger/internal/alert"
+	"dilogger/internal/model"
+	"dilogger/internal/series"
+	"slices"
+	"time"
+
+	"github.com/pocketbase/dbx"
+)
+
+// Result of a backtest over the stored history
+type BacktestResult struct {
+	From      time.Time       `json:"from"`
+	To        time.Time       `json:"to"`
+	Products  int             `json:"products"`
+	Evaluated int             `json:"evaluated"`
+	Triggers  []alert.Trigger `json:"triggers"`
+}
+
+// The `Backtest` function replays the stored history of the rule's product, or of every product, through the alert evaluator.
+func (s *Server) Backtest(rule alert.Rule, from, to time.Time) (BacktestResult, error) {
+	result := BacktestResult{From: from, To: to, Triggers: []alert.Trigger{}}
+	productIds := []string{rule.Product}
+	if rule.Product == "" {
+		records, err := s.App.FindAllRecords("products")
+		if err != nil {
+			return result, err
+		}
+		productIds = productIds[:0]
+		for _, record := range records {
+			productIds = append(productIds, record.Id)
+		}
+	}
+	for _, productId := range productIds {
+		history, err := s.ProductHistory(productId, to)
+		if err != nil {
+			return result, err
+		}
+		triggers, evaluated := alert.Backtest(rule, history, from, to)
+		result.Products++
+		result.Evaluated += evaluated
+		result.Triggers = append(result.Triggers, triggers...)
+	}
+	slices.SortStableFunc(result.Triggers, func(a, b alert.Trigger) int { return a.Time.Compare(b.Time) })
+	return result, nil
+}
+
+// Get the price change points and daily stock of a product stored until the given time
+func (s *Server) ProductHistory(productId string, to time.Time) (alert.History, error) {
+	product, err := s.FindProduct(productId)
+	if err != nil {
+		return alert.History{}, err
+	}
+	intervals, err := s.GetPriceIntervals(productId, time.Unix(0, 0), to)
+	if err != nil {
+		return alert.History{}, err
+	}
+	stock, err := s.GetDailyStock(productId, time.Unix(0, 0), to)
+	if err != nil {
+		return alert.History{}, err
+	}
+	return alert.History{
+		Product:   product,
+		Points:    series.Points(intervals, time.Unix(0, 0), to),
+		Stock:     stock,
+		Discounts: DiscountRules(),
+	}, nil
+}
+
+// Get the stock of a product recorded by the daily rollups between from and to
+func (s *Server) GetDailyStock(productId string, from, to time.Time) ([]model.StockPoint, error) {
+	records, err := s.App.FindRecordsByFilter(
+		"price_daily",
+		"product = {:product} && day >= {:from} && day <= {:to}",
+		"day", 0, 0,
+		dbx.Params{"product": productId, "from": FormatDate(from), "to": FormatDate(to)},
+	)
+	if err != nil {
+		return nil, err
+	}
+	points := []model.StockPoint{}
+	for _, record := range records {
+		points = append(points, model.StockPoint{
+			Time:  record.GetDateTime("day").Time(),
+			Stock: record.GetInt("stock"),
+		})
+	}
+	return points, nil
+}
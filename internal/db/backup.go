@@ -0,0 +1,204 @@
+package db
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/archive"
+	"github.com/pocketbase/pocketbase/tools/osutils"
+	"github.com/pocketbase/pocketbase/tools/security"
+)
+
+// Prefix and time layout of the backup archive names
+const (
+	backupPrefix = "pb_backup_"
+	backupLayout = "20060102_150405"
+)
+
+// Create a timestamped backup archive of the data directory inside dir and return its path
+func (s *Server) CreateBackup(dir string) (string, error) {
+	if s.App.Settings().Backups.S3.Enabled {
+		return "", errors.New("backups stored on S3 are not supported, disable S3 backups in the settings")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	name := backupPrefix + time.Now().UTC().Format(backupLayout) + ".zip"
+	if err := s.App.CreateBackup(context.Background(), name); err != nil {
+		return "", err
+	}
+	src := filepath.Join(s.App.DataDir(), core.LocalBackupsDirName, name)
+	dst := filepath.Join(dir, name)
+	if src == dst {
+		return dst, nil
+	}
+	if err := os.Rename(src, dst); err != nil {
+		// the directory may be on another device, so the archive is copied instead
+		if err := copyFile(src, dst); err != nil {
+			return "", err
+		}
+		os.Remove(src)
+	}
+	return dst, nil
+}
+
+// Delete backups in dir except the newest one of each of the last daily days and weekly weeks
+func (s *Server) RotateBackups(dir string, daily int, weekly int) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	type backup struct {
+		name string
+		time time.Time
+	}
+	var backups []backup
+	for _, entry := range entries {
+		name := entry.Name()
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, backupPrefix), ".zip")
+		t, err := time.Parse(backupLayout, stamp)
+		if entry.IsDir() || !strings.HasPrefix(name, backupPrefix) || err != nil {
+			continue
+		}
+		backups = append(backups, backup{name, t})
+	}
+	slices.SortFunc(backups, func(a, b backup) int { return b.time.Compare(a.time) })
+
+	var days, weeks []string
+	var removed []string
+	for _, b := range backups {
+		day := b.time.Format(time.DateOnly)
+		year, week := b.time.ISOWeek()
+		weekKey := fmt.Sprintf("%d-%d", year, week)
+		keep := false
+		if !slices.Contains(days, day) && len(days) < daily {
+			days = append(days, day)
+			keep = true
+		}
+		if !slices.Contains(weeks, weekKey) && len(weeks) < weekly {
+			weeks = append(weeks, weekKey)
+			keep = true
+		}
+		if keep {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, b.name)); err != nil {
+			return removed, err
+		}
+		removed = append(removed, b.name)
+	}
+	return removed, nil
+}
+
+// Validate a backup archive and replace the content of the data directory with it.
+// The restore is refused while a server started in the background is running, unless it is forced.
+func (s *Server) RestoreBackup(path string, force bool) error {
+	dataDir := s.App.DataDir()
+	if pid, running := serverRunning(filepath.Join(dataDir, ".pid")); running && !force {
+		return fmt.Errorf("a server is running on this data directory (pid=%d), stop it before restoring", pid)
+	}
+	if err := ValidateBackup(path); err != nil {
+		return err
+	}
+
+	exclude := []string{core.LocalBackupsDirName, core.LocalTempDirName, core.LocalAutocertCacheDirName}
+	tempDir := filepath.Join(dataDir, core.LocalTempDirName)
+	extractedDir := filepath.Join(tempDir, "pb_restore_"+security.PseudorandomString(8))
+	defer os.RemoveAll(extractedDir)
+	if err := archive.Extract(path, extractedDir); err != nil {
+		return err
+	}
+	if err := checkDatabase(filepath.Join(extractedDir, "data.db")); err != nil {
+		return err
+	}
+
+	// close the databases before their files are moved, the old data is removed on the next start
+	if err := s.App.ResetBootstrapState(); err != nil {
+		return err
+	}
+	oldDir := filepath.Join(tempDir, "old_pb_data_"+security.PseudorandomString(8))
+	if err := osutils.MoveDirContent(dataDir, oldDir, exclude...); err != nil {
+		return fmt.Errorf("failed to move the current data: %w", err)
+	}
+	if err := osutils.MoveDirContent(extractedDir, dataDir, exclude...); err != nil {
+		if revertErr := osutils.MoveDirContent(oldDir, dataDir, exclude...); revertErr != nil {
+			return fmt.Errorf("failed to revert the data directory: %w", revertErr)
+		}
+		return fmt.Errorf("failed to move the restored data: %w", err)
+	}
+	return nil
+}
+
+// Check whether the process of a pid file is still alive, a file left behind by a crash is removed
+func serverRunning(pidFile string) (int, bool) {
+	content, err := os.ReadFile(pidFile)
+	if err != nil {
+		return 0, false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err == nil && processAlive(pid) {
+		return pid, true
+	}
+	os.Remove(pidFile)
+	return pid, false
+}
+
+// Check that the file is a readable zip archive containing a database
+func ValidateBackup(path string) error {
+	reader, err := zip.OpenReader(path)
+	if err != nil {
+		return fmt.Errorf("invalid backup archive: %w", err)
+	}
+	defer reader.Close()
+	for _, file := range reader.File {
+		if file.Name == "data.db" {
+			return nil
+		}
+	}
+	return errors.New("invalid backup archive: data.db is missing")
+}
+
+// Run an integrity check on the database file
+func checkDatabase(path string) error {
+	db, err := core.DefaultDBConnect(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	var result string
+	if err := db.NewQuery("PRAGMA integrity_check").Row(&result); err != nil {
+		return err
+	}
+	if result != "ok" {
+		return fmt.Errorf("backup database is corrupted: %s", result)
+	}
+	return nil
+}
+
+// Copy the content of a file
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
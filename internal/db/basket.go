@@ -0,0 +1,236 @@
+package db
+
+import (
+	"dilogger/internal/model"
+	"dilogger/internal/push"
+	"dilogger/internal/series"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Create new basket total and budget collections in database
+func (s *Server) NewBasketCollections() {
+	if _, err := s.App.FindCollectionByNameOrId("basket_totals"); err != nil {
+		if err := s.App.Save(NewCollection("basket_totals")); err != nil {
+			s.logger.Error(err.Error())
+			return
+		}
+	}
+	if _, err := s.App.FindCollectionByNameOrId("basket_budgets"); err == nil {
+		return
+	}
+	users, err := s.App.FindCollectionByNameOrId("users")
+	if err != nil {
+		s.logger.Error(err.Error())
+		return
+	}
+	urls, err := s.App.FindCollectionByNameOrId("urls")
+	if err != nil {
+		s.logger.Error(err.Error())
+		return
+	}
+	if err := s.App.Save(NewCollection("basket_budgets", users.Id, urls.Id, UserChannels)); err != nil {
+		s.logger.Error(err.Error())
+	}
+}
+
+// Check that the budgets are set on wishlist URLs when they are saved
+func (s *Server) BasketBudgetHook() {
+	s.App.OnRecordValidate("basket_budgets").BindFunc(func(e *core.RecordEvent) error {
+		wishlist, err := s.App.FindRecordById("urls", e.Record.GetString("wishlist"))
+		if err != nil || wishlist.GetString("type") != "wishlist" {
+			return validation.Errors{"wishlist": validation.NewError("validation_invalid_wishlist", "budgets are set on wishlist URLs")}
+		}
+		return e.Next()
+	})
+}
+
+// The `UpdateBasketTotals` function stores the total of the wishlist URLs scraped in a run and notifies the budgets they dropped under.
+func (s *Server) UpdateBasketTotals(urls []string) {
+	for _, url := range urls {
+		wishlist, err := s.App.FindFirstRecordByData("urls", "url", url)
+		if err != nil || wishlist.GetString("type") != "wishlist" {
+			continue
+		}
+		total, err := s.BasketTotal(url)
+		if err != nil {
+			s.logger.Error(err.Error())
+			continue
+		}
+		s.saveBasketTotal(total)
+		s.checkBudgets(wishlist.Id, total)
+	}
+}
+
+// The `BasketTotal` function adds up the current prices of the products listed on a URL multiplied by their quantities.
+func (s *Server) BasketTotal(url string) (model.BasketTotal, error) {
+	total := model.BasketTotal{Url: url, Updated: time.Now()}
+	records, err := s.App.FindAllRecords("listings", dbx.HashExp{"url": url, "listed": true})
+	if err != nil {
+		return total, err
+	}
+	for _, record := range records {
+		product, err := s.FindProduct(record.GetString("product"))
+		if err != nil {
+			s.logger.Error(err.Error())
+			continue
+		}
+		value := product.Price * float64(max(record.GetInt("quantity"), 1))
+		total.Items++
+		total.Total += value
+		if product.Stock > 0 {
+			total.InStockTotal += value
+		} else {
+			total.OutOfStock++
+		}
+	}
+	return total, nil
+}
+
+// Save the total of a URL, extending the last total record when nothing changed like a revived price
+func (s *Server) saveBasketTotal(total model.BasketTotal) {
+	collection, err := s.App.FindCachedCollectionByNameOrId("basket_totals")
+	if err != nil {
+		s.logger.Error(err.Error())
+		return
+	}
+	record := core.NewRecord(collection)
+	last, err := s.App.FindRecordsByFilter(collection, "url = {:url}", "-created", 1, 0, dbx.Params{"url": total.Url})
+	if err == nil && len(last) > 0 {
+		previous := basketTotal(last[0])
+		if previous.Total == total.Total && previous.InStockTotal == total.InStockTotal &&
+			previous.Items == total.Items && previous.OutOfStock == total.OutOfStock {
+			record = last[0]
+		}
+	}
+	record.Set("url", total.Url)
+	record.Set("total", total.Total)
+	record.Set("in_stock_total", total.InStockTotal)
+	record.Set("items", total.Items)
+	record.Set("out_of_stock", total.OutOfStock)
+	if err := s.App.Save(record); err != nil {
+		s.logger.Error(err.Error())
+	}
+}
+
+// Create basket total from its record
+func basketTotal(record *core.Record) model.BasketTotal {
+	return model.BasketTotal{
+		Url:          record.GetString("url"),
+		Total:        record.GetFloat("total"),
+		InStockTotal: record.GetFloat("in_stock_total"),
+		Items:        record.GetInt("items"),
+		OutOfStock:   record.GetInt("out_of_stock"),
+		Updated:      record.GetDateTime("updated").Time(),
+	}
+}
+
+// Notify the budgets of a wishlist once when its total drops under them, a budget is notified again after the total went back above it.
+// Budgets with in_stock_only compare the total of the products in stock.
+func (s *Server) checkBudgets(wishlistId string, total model.BasketTotal) {
+	records, err := s.App.FindAllRecords("basket_budgets", dbx.HashExp{"wishlist": wishlistId})
+	if err != nil {
+		s.logger.Error(err.Error())
+		return
+	}
+	for _, record := range records {
+		value := total.Total
+		if record.GetBool("in_stock_only") {
+			value = total.InStockTotal
+		}
+		target := record.GetFloat("target")
+		// an empty basket is not under budget
+		under := value > 0 && value < target
+		if under == record.GetBool("notified") {
+			continue
+		}
+		record.Set("notified", under)
+		if err := s.App.Save(record); err != nil {
+			s.logger.Error(err.Error())
+			continue
+		}
+		if !under {
+			continue
+		}
+		// budget alerts are only sent when the total crosses the budget, the throttling only holds them in quiet hours
+		event := push.Event{
+			Type:     push.Budget,
+			Product:  model.Product{Url: total.Url},
+			OldPrice: target,
+			NewPrice: value,
+			Time:     time.Now(),
+			Targets:  userChannelTargets(record.GetString("user"), record.GetStringSlice("channels")),
+		}
+		if event, ok := s.Throttle(event); ok {
+			s.Notification.Send(event)
+			s.LogSentNotification(event)
+		}
+	}
+}
+
+// Get the latest total of every wishlist URL, identified by the id of its URL record
+func (s *Server) Baskets() ([]model.BasketTotal, error) {
+	wishlists, err := s.App.FindAllRecords("urls", dbx.HashExp{"type": "wishlist"})
+	if err != nil {
+		return nil, err
+	}
+	baskets := make([]model.BasketTotal, 0, len(wishlists))
+	for _, wishlist := range wishlists {
+		basket := model.BasketTotal{Url: wishlist.GetString("url")}
+		last, err := s.App.FindRecordsByFilter("basket_totals", "url = {:url}", "-created", 1, 0, dbx.Params{"url": basket.Url})
+		if err != nil {
+			return nil, err
+		}
+		if len(last) > 0 {
+			basket = basketTotal(last[0])
+		}
+		basket.Id = wishlist.Id
+		baskets = append(baskets, basket)
+	}
+	return baskets, nil
+}
+
+// Build the total history of a wishlist URL between from and to, grouped by the given bucket like the price history
+func (s *Server) BasketHistory(wishlistId string, from, to time.Time, bucket series.Bucket, inStockOnly bool) (model.BasketHistory, error) {
+	wishlist, err := s.App.FindRecordById("urls", wishlistId)
+	if err != nil {
+		return model.BasketHistory{}, err
+	}
+	history := model.BasketHistory{
+		Url:         wishlist.GetString("url"),
+		InStockOnly: inStockOnly,
+		Bucket:      string(bucket),
+		From:        from,
+		To:          to,
+	}
+	params := dbx.Params{"url": history.Url, "from": FormatDate(from), "to": FormatDate(to)}
+	records, err := s.App.FindRecordsByFilter("basket_totals", "url = {:url} && updated >= {:from} && created <= {:to}", "created", 0, 0, params)
+	if err != nil {
+		return model.BasketHistory{}, err
+	}
+	before, err := s.App.FindRecordsByFilter("basket_totals", "url = {:url} && updated < {:from}", "-updated", 1, 0, params)
+	if err != nil {
+		return model.BasketHistory{}, err
+	}
+	var intervals []model.PriceInterval
+	for _, record := range append(before, records...) {
+		total := record.GetFloat("total")
+		if inStockOnly {
+			total = record.GetFloat("in_stock_total")
+		}
+		intervals = append(intervals, model.PriceInterval{
+			Price: total,
+			Start: record.GetDateTime("created").Time(),
+			End:   record.GetDateTime("updated").Time(),
+		})
+	}
+	if bucket == series.Raw {
+		history.Points = series.Points(intervals, from, to)
+	} else {
+		history.Points = series.Fill(intervals, bucket, from, to)
+	}
+	return history, nil
+}
@@ -0,0 +1,141 @@
+package db
+
+import (
+	"cmp"
+	"dilogger/internal/alert"
+	"dilogger/internal/push"
+	"slices"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Type of the digests in the notification log
+const digestEvent = "digest"
+
+// Get the digest schedule of a user record, nil when the user has no digest
+func (s *Server) DigestSchedule(user *core.Record) (*alert.Schedule, error) {
+	return alert.ParseSchedule(
+		user.GetString("digest"),
+		user.GetString("digest_time"),
+		user.GetString("digest_day"),
+		user.GetString("timezone"),
+	)
+}
+
+// The `SendDigests` function sends the digest of every user whose scheduled time passed since the last digest.
+// A user without `digest_channels` receives the digest by email. A digest which no backend delivered is logged as failed,
+// its deliveries are retried with the failed notifications.
+func (s *Server) SendDigests() {
+	users, err := s.App.FindAllRecords("users", dbx.Not(dbx.HashExp{"digest": ""}))
+	if err != nil {
+		s.logger.Error(err.Error())
+		return
+	}
+	now := time.Now()
+	for _, user := range users {
+		schedule, err := s.DigestSchedule(user)
+		if err != nil || schedule == nil {
+			continue
+		}
+		scheduled := schedule.Last(now)
+		sent, err := s.App.CountRecords("notification_log",
+			dbx.HashExp{"event": digestEvent, "user": user.Id},
+			dbx.NewExp("created >= {:scheduled}", dbx.Params{"scheduled": FormatDate(scheduled)}),
+		)
+		if err != nil || sent > 0 {
+			continue
+		}
+		digest, err := s.BuildDigest(user, schedule.Period, schedule.Start(scheduled), scheduled)
+		if err != nil {
+			s.logger.Error(err.Error(), "user", user.Id)
+			continue
+		}
+		event := push.Event{Type: digestEvent, Time: scheduled}
+		if digest.Empty() {
+			s.logNotification(event, user.Id, NotificationSuppressed, "empty_digest")
+			continue
+		}
+		channels := user.GetStringSlice("digest_channels")
+		if len(channels) == 0 {
+			channels = []string{"email"}
+		}
+		results := s.Notification.SendDigest(digest, channels)
+		switch {
+		case len(results) == 0:
+			s.logNotification(event, user.Id, NotificationSuppressed, "no_backend")
+		case slices.ContainsFunc(results, func(result push.Result) bool { return result.Error == "" }):
+			s.logNotification(event, user.Id, NotificationSent, "")
+		default:
+			s.logNotification(event, user.Id, NotificationFailed, "delivery_failed")
+		}
+	}
+}
+
+// The `BuildDigest` function collects the changes between from and to of the products watched by the user.
+// The user watches the products of the user's active alert rules, or every product without such a rule or with a rule for every product.
+func (s *Server) BuildDigest(user *core.Record, period string, from, to time.Time) (push.Digest, error) {
+	digest := push.Digest{
+		User:   push.Recipient{Id: user.Id, Name: user.GetString("name"), Email: user.Email()},
+		Period: period,
+		From:   from,
+		To:     to,
+	}
+	productIds, err := s.watchedProducts(user.Id)
+	if err != nil {
+		return digest, err
+	}
+	var lastRun types.DateTime
+	err = s.App.DB().
+		Select("COALESCE(MAX(updated), '')").
+		From("prices").
+		Where(dbx.NewExp("updated <= {:to}", dbx.Params{"to": FormatDate(to)})).
+		Row(&lastRun)
+	if err != nil {
+		return digest, err
+	}
+	for _, productId := range productIds {
+		history, err := s.ProductHistory(productId, to)
+		if err != nil {
+			return digest, err
+		}
+		if item, ok := alert.DigestItem(history, from, to, lastRun.Time()); ok {
+			digest.Items = append(digest.Items, item)
+		}
+	}
+	slices.SortStableFunc(digest.Items, func(a, b push.DigestItem) int {
+		return cmp.Compare(a.ChangePercent(), b.ChangePercent())
+	})
+	return digest, nil
+}
+
+// Get the ids of the products watched by a user
+func (s *Server) watchedProducts(userId string) ([]string, error) {
+	rules, err := s.App.FindAllRecords("alert_rules", dbx.HashExp{"user": userId, "active": true})
+	if err != nil {
+		return nil, err
+	}
+	var productIds []string
+	for _, rule := range rules {
+		if rule.GetString("product") == "" {
+			productIds = nil
+			break
+		}
+		if !slices.Contains(productIds, rule.GetString("product")) {
+			productIds = append(productIds, rule.GetString("product"))
+		}
+	}
+	if len(productIds) > 0 {
+		return productIds, nil
+	}
+	products, err := s.App.FindAllRecords("products")
+	if err != nil {
+		return nil, err
+	}
+	for _, product := range products {
+		productIds = append(productIds, product.Id)
+	}
+	return productIds, nil
+}
@@ -0,0 +1,133 @@
+package db
+
+import (
+	"dilogger/internal/model"
+	"dilogger/internal/series"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Find product by id along with its latest price
+func (s *Server) FindProduct(productId string) (model.Product, error) {
+	record, err := s.App.FindRecordById("products", productId)
+	if err != nil {
+		return model.Product{}, err
+	}
+	product := model.Product{
+		Id:        record.Id,
+		Name:      record.GetString("name"),
+		Stock:     int32(record.GetInt("stock")),
+		CreatedAt: record.GetDateTime("created").Time(),
+		UpdatedAt: record.GetDateTime("updated").Time(),
+	}
+	latest, err := s.App.FindRecordsByFilter(
+		"prices",
+		"product = {:product}",
+		"-updated", 1, 0,
+		dbx.Params{"product": productId},
+	)
+	if err == nil && len(latest) > 0 {
+		product.Price = latest[0].GetFloat("price")
+	}
+	return product, nil
+}
+
+// Get price intervals of a product overlapping the given time range, including the last one seen before it
+func (s *Server) GetPriceIntervals(productId string, from, to time.Time) ([]model.PriceInterval, error) {
+	params := dbx.Params{
+		"product": productId,
+		"from":    FormatDate(from),
+		"to":      FormatDate(to),
+	}
+	records, err := s.App.FindRecordsByFilter(
+		"prices",
+		"product = {:product} && updated >= {:from} && created <= {:to}",
+		"created", 0, 0,
+		params,
+	)
+	if err != nil {
+		return nil, err
+	}
+	before, err := s.App.FindRecordsByFilter(
+		"prices",
+		"product = {:product} && updated < {:from}",
+		"-updated", 1, 0,
+		params,
+	)
+	if err != nil {
+		return nil, err
+	}
+	var intervals []model.PriceInterval
+	for _, record := range append(before, records...) {
+		intervals = append(intervals, model.PriceInterval{
+			Price: record.GetFloat("price"),
+			Start: record.GetDateTime("created").Time(),
+			End:   record.GetDateTime("updated").Time(),
+		})
+	}
+	return intervals, nil
+}
+
+// Build the price history of a product between from and to, grouped by the given bucket
+func (s *Server) PriceHistory(productId string, from, to time.Time, bucket series.Bucket) (model.PriceHistory, error) {
+	product, err := s.FindProduct(productId)
+	if err != nil {
+		return model.PriceHistory{}, err
+	}
+	history := model.PriceHistory{
+		Product: product,
+		Bucket:  string(bucket),
+		From:    from,
+		To:      to,
+	}
+	// stock is only recorded by the daily rollups, followed by the current stock
+	history.Stock, err = s.GetDailyStock(productId, series.Day.Start(from.UTC()), to)
+	if err != nil {
+		return model.PriceHistory{}, err
+	}
+	since := product.UpdatedAt
+	if since.Before(from) {
+		since = from
+	}
+	if n := len(history.Stock); !since.After(to) && (n == 0 || since.After(history.Stock[n-1].Time)) {
+		history.Stock = append(history.Stock, model.StockPoint{Time: since, Stock: int(product.Stock)})
+	}
+	if bucket == series.Week {
+		// weeks are merged from whole days, so the first week starts on its monday like in series.Fill
+		from = series.Week.Start(from)
+	}
+	// daily rollups are stored in UTC, so only UTC ranges of days or weeks are served from them
+	var rolled []model.PricePoint
+	if (bucket == series.Day || bucket == series.Week) && from.Location() == time.UTC {
+		rolled, err = s.GetDailyPrices(productId, from, to)
+		if err != nil {
+			return model.PriceHistory{}, err
+		}
+		if len(rolled) > 0 {
+			// the last rolled up day may be partial, so it is computed again from raw prices
+			from = rolled[len(rolled)-1].Time
+			rolled = rolled[:len(rolled)-1]
+		}
+	}
+	intervals, err := s.GetPriceIntervals(productId, from, to)
+	if err != nil {
+		return model.PriceHistory{}, err
+	}
+	switch bucket {
+	case series.Raw:
+		history.Points = series.Points(intervals, from, to)
+	case series.Week:
+		history.Points = series.Merge(append(rolled, series.Fill(intervals, series.Day, from, to)...), series.Week)
+	default:
+		history.Points = append(rolled, series.Fill(intervals, bucket, from, to)...)
+	}
+	return history, nil
+}
+
+// Format time the way dates are stored in the database
+func FormatDate(t time.Time) string {
+	date, _ := types.ParseDateTime(t)
+	return date.String()
+}
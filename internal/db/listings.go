@@ -0,0 +1,149 @@
+package db
+
+import (
+	"dilogger/internal/alert"
+	"dilogger/internal/push"
+	"slices"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Rule of older versions letting any logged in user edit the quantity of the shared listings
+const oldListingUpdateRule = "@request.auth.id != '' && @request.body.product:isset = false && @request.body.url:isset = false" +
+	" && @request.body.first_seen:isset = false && @request.body.last_seen:isset = false && @request.body.listed:isset = false"
+
+// Quantity of a product in a wishlist counted in the basket totals, an empty quantity counts once
+func listingQuantityField() *core.NumberField {
+	return &core.NumberField{
+		Name:    "quantity",
+		OnlyInt: true,
+		Min:     types.Pointer(1.0),
+	}
+}
+
+// Create new listing collection in database, storing the URLs on which every product was seen.
+// The quantity missing from older versions is added and only superusers may edit it, like in new collections.
+func (s *Server) NewListingCollection() {
+	if collection, err := s.App.FindCollectionByNameOrId("listings"); err == nil {
+		changed := false
+		if quantity, ok := collection.Fields.GetByName("quantity").(*core.NumberField); !ok {
+			collection.Fields.Add(listingQuantityField())
+			changed = true
+		} else if quantity.Min == nil || *quantity.Min != 1 {
+			quantity.Min = types.Pointer(1.0)
+			changed = true
+		}
+		if collection.UpdateRule != nil && *collection.UpdateRule == oldListingUpdateRule {
+			collection.UpdateRule = nil
+			changed = true
+		}
+		if !changed {
+			return
+		}
+		if err := s.App.Save(collection); err != nil {
+			s.logger.Error(err.Error())
+		}
+		return
+	}
+	products, err := s.App.FindCollectionByNameOrId("products")
+	if err != nil {
+		s.logger.Error(err.Error())
+		return
+	}
+	if err := s.App.Save(NewCollection("listings", products.Id)); err != nil {
+		s.logger.Error(err.Error())
+	}
+}
+
+// The `UpdateListings` function records the URLs on which the products of a run were seen, products lists the ids of the products scraped from each URL.
+// The products added to or removed from a URL since the previous run are notified, and the products no longer listed on any URL are archived.
+// A URL without any product in the run is skipped, since a failed scrape cannot be told apart from an emptied wishlist,
+// and the products of a URL scraped for the first time are not notified.
+func (s *Server) UpdateListings(products map[string][]string) {
+	collection, err := s.App.FindCachedCollectionByNameOrId("listings")
+	if err != nil {
+		s.logger.Error(err.Error())
+		return
+	}
+	now := time.Now()
+	changed := map[string]string{}
+	for url, ids := range products {
+		records, err := s.App.FindAllRecords(collection, dbx.HashExp{"url": url})
+		if err != nil {
+			s.logger.Error(err.Error())
+			continue
+		}
+		firstScrape := len(records) == 0
+		for _, id := range ids {
+			index := slices.IndexFunc(records, func(record *core.Record) bool { return record.GetString("product") == id })
+			record := core.NewRecord(collection)
+			if index >= 0 {
+				record = records[index]
+			} else {
+				record.Set("product", id)
+				record.Set("url", url)
+				record.Set("first_seen", now)
+			}
+			if !record.GetBool("listed") && !firstScrape {
+				changed[id] = push.Listed
+			}
+			record.Set("listed", true)
+			record.Set("last_seen", now)
+			if err := s.App.Save(record); err != nil {
+				s.logger.Error(err.Error())
+			}
+		}
+		for _, record := range records {
+			if !record.GetBool("listed") || slices.Contains(ids, record.GetString("product")) {
+				continue
+			}
+			record.Set("listed", false)
+			if err := s.App.Save(record); err != nil {
+				s.logger.Error(err.Error())
+				continue
+			}
+			if _, ok := changed[record.GetString("product")]; !ok {
+				changed[record.GetString("product")] = push.Delisted
+			}
+		}
+	}
+	for id, eventType := range changed {
+		s.updateArchived(id, eventType)
+	}
+}
+
+// Archive a product no longer listed on any URL, or restore a listed one, and notify the change of its listings.
+// A product removed from a URL but still listed on another one is not notified.
+func (s *Server) updateArchived(productId string, eventType string) {
+	listed, err := s.App.CountRecords("listings", dbx.HashExp{"product": productId, "listed": true})
+	if err != nil {
+		s.logger.Error(err.Error())
+		return
+	}
+	if eventType == push.Delisted && listed > 0 {
+		return
+	}
+	record, err := s.App.FindRecordById("products", productId)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return
+	}
+	record.Set("archived", listed == 0)
+	if err := s.App.Save(record); err != nil {
+		s.logger.Error(err.Error())
+	}
+	product, err := s.FindProduct(productId)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return
+	}
+	s.SendAlert(alert.Input{Event: push.Event{
+		Type:     eventType,
+		Product:  product,
+		OldPrice: product.Price,
+		NewPrice: product.Price,
+	}})
+}
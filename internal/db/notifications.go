@@ -0,0 +1,174 @@
+package db
+
+import (
+	"dilogger/internal/push"
+	"dilogger/internal/utils"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Statuses of the outbound notifications
+const (
+	DeliverySent     = "sent"
+	DeliveryRetrying = "retrying"
+	DeliverySending  = "sending"
+	DeliveryFailed   = "failed"
+)
+
+// Statuses which can be stored in the notifications collection
+var DeliveryStatuses = []string{DeliverySent, DeliveryRetrying, DeliverySending, DeliveryFailed}
+
+// Create new notifications collection in database with the view of the recent failures
+func (s *Server) NewNotificationCollection() {
+	if collection, err := s.App.FindCollectionByNameOrId("notifications"); err != nil {
+		if err := s.App.Save(NewCollection("notifications", DeliveryStatuses)); err != nil {
+			s.logger.Error(err.Error())
+			return
+		}
+	} else {
+		s.upgradeNotificationCollection(collection)
+	}
+	if _, err := s.App.FindCollectionByNameOrId("notification_failures"); err == nil {
+		return
+	}
+	view := core.NewViewCollection("notification_failures")
+	view.ViewQuery = "SELECT id, backend, event, product, status, attempts, last_error, next_attempt, updated FROM notifications WHERE status != 'sent' ORDER BY updated DESC LIMIT 200"
+	if err := s.App.Save(view); err != nil {
+		s.logger.Error(err.Error())
+	}
+}
+
+// Add the recipient and the sending status to a notifications collection created before they existed
+func (s *Server) upgradeNotificationCollection(collection *core.Collection) {
+	status, ok := collection.Fields.GetByName("status").(*core.SelectField)
+	if !ok || collection.Fields.GetByName("recipient") != nil {
+		return
+	}
+	status.Values = DeliveryStatuses
+	collection.Fields.Add(&core.TextField{
+		Name: "recipient",
+	})
+	if err := s.App.Save(collection); err != nil {
+		s.logger.Error(err.Error())
+	}
+}
+
+// The `SaveNotification` function stores the outbound notification of a job with its result.
+// A failed job is retried with an exponential backoff until it ran NOTIFY_RETRIES times.
+// When only some recipients or channels of the job failed, a job is stored for each of them so that its retry does not deliver again to the others,
+// and only with the events they did not receive yet.
+func (s *Server) SaveNotification(job push.Job, result push.Result) {
+	if job.Recipient != "" || len(result.Failed) == 0 {
+		s.saveNotification(job, result)
+		return
+	}
+	for i, recipient := range result.Failed {
+		recipientJob := job
+		recipientJob.Recipient = recipient
+		if i > 0 {
+			recipientJob.Id = ""
+		}
+		s.saveNotification(recipientJob, result)
+	}
+}
+
+// Store the outbound notification of a job with its result
+func (s *Server) saveNotification(job push.Job, result push.Result) {
+	collection, err := s.App.FindCachedCollectionByNameOrId("notifications")
+	if err != nil {
+		s.logger.Error(err.Error())
+		return
+	}
+	record := core.NewRecord(collection)
+	if job.Id != "" {
+		if record, err = s.App.FindRecordById(collection, job.Id); err != nil {
+			s.logger.Error(err.Error())
+			return
+		}
+	}
+	status := DeliverySent
+	record.Set("next_attempt", "")
+	if result.Error != "" {
+		status = DeliveryFailed
+		if job.Attempts < utils.GetEnvInt("NOTIFY_RETRIES", 5) {
+			status = DeliveryRetrying
+			backoff := time.Minute << (job.Attempts - 1)
+			record.Set("next_attempt", time.Now().Add(backoff))
+		}
+	}
+	if pending, ok := result.Pending[job.Recipient]; ok {
+		// the delivered events are not sent again by the retry
+		job.Events = pending
+	}
+	record.Set("backend", job.Backend)
+	record.Set("event", job.EventType())
+	record.Set("product", job.Product())
+	record.Set("recipient", job.Recipient)
+	record.Set("status", status)
+	record.Set("attempts", job.Attempts)
+	record.Set("last_error", result.Error)
+	if result.ResponseID != "" {
+		record.Set("response_id", result.ResponseID)
+	}
+	job.Id = ""
+	record.Set("payload", job)
+	if err := s.App.Save(record); err != nil {
+		s.logger.Error(err.Error())
+	}
+}
+
+// The `RetryNotifications` function runs again the failed notifications whose next attempt is due.
+// A notification is claimed as `sending` before it runs, and a call returns at once while the previous one is still running.
+// A notification still `sending` after NOTIFY_SENDING_TIMEOUT_MINUTES was left by a crash and is claimed again.
+func (s *Server) RetryNotifications() {
+	if !s.retryMu.TryLock() {
+		return
+	}
+	defer s.retryMu.Unlock()
+	now := time.Now()
+	timeout := time.Duration(utils.GetEnvInt("NOTIFY_SENDING_TIMEOUT_MINUTES", 10)) * time.Minute
+	records, err := s.App.FindRecordsByFilter(
+		"notifications",
+		"(status = {:retrying} && next_attempt <= {:now}) || (status = {:sending} && updated <= {:stale})",
+		"next_attempt", 0, 0,
+		dbx.Params{
+			"retrying": DeliveryRetrying,
+			"sending":  DeliverySending,
+			"now":      FormatDate(now),
+			"stale":    FormatDate(now.Add(-timeout)),
+		},
+	)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return
+	}
+	for _, record := range records {
+		var job push.Job
+		if err := record.UnmarshalJSONField("payload", &job); err != nil {
+			s.logger.Error(err.Error(), "notification", record.Id)
+			continue
+		}
+		if !s.claimNotification(record) {
+			continue
+		}
+		job.Id = record.Id
+		job.Attempts = record.GetInt("attempts")
+		s.Notification.Retry(job)
+	}
+}
+
+// Set the status of a retrying or stale sending notification to sending, false when it was changed since it was read
+func (s *Server) claimNotification(record *core.Record) bool {
+	result, err := s.App.DB().Update("notifications",
+		dbx.Params{"status": DeliverySending, "updated": FormatDate(time.Now())},
+		dbx.HashExp{"id": record.Id, "status": record.GetString("status"), "updated": record.GetString("updated")},
+	).Execute()
+	if err != nil {
+		s.logger.Error(err.Error(), "notification", record.Id)
+		return false
+	}
+	claimed, err := result.RowsAffected()
+	return err == nil && claimed == 1
+}
@@ -0,0 +1,58 @@
+package db
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Create new OneSignal subscription collection in database, moving the subscriptions of the `onesignal_id` user field of older versions
+func (s *Server) NewOneSignalSubscriptionCollection() {
+	if _, err := s.App.FindCollectionByNameOrId("onesignal_subscriptions"); err == nil {
+		return
+	}
+	users, err := s.App.FindCollectionByNameOrId("users")
+	if err != nil {
+		s.logger.Error(err.Error())
+		return
+	}
+	if err := s.App.Save(NewCollection("onesignal_subscriptions", users.Id)); err != nil {
+		s.logger.Error(err.Error())
+		return
+	}
+	if users.Fields.GetByName("onesignal_id") == nil {
+		return
+	}
+	linked, err := s.App.FindAllRecords("users", dbx.Not(dbx.HashExp{"onesignal_id": ""}))
+	if err != nil {
+		s.logger.Error(err.Error())
+		return
+	}
+	for _, user := range linked {
+		if _, err := s.SaveOneSignalSubscription(user.Id, user.GetString("onesignal_id"), ""); err != nil {
+			s.logger.Error(err.Error(), "user", user.Id)
+		}
+	}
+	users.Fields.RemoveByName("onesignal_id")
+	if err := s.App.Save(users); err != nil {
+		s.logger.Error(err.Error())
+	}
+}
+
+// Save the OneSignal subscription of a browser for a user, ErrSubscriptionTaken is returned when it belongs to another user
+func (s *Server) SaveOneSignalSubscription(userId string, subscriptionId string, userAgent string) (*core.Record, error) {
+	record, err := s.App.FindFirstRecordByData("onesignal_subscriptions", "subscription", subscriptionId)
+	if err == nil && record.GetString("user") != userId {
+		return nil, ErrSubscriptionTaken
+	}
+	if err != nil {
+		collection, err := s.App.FindCachedCollectionByNameOrId("onesignal_subscriptions")
+		if err != nil {
+			return nil, err
+		}
+		record = core.NewRecord(collection)
+		record.Set("subscription", subscriptionId)
+	}
+	record.Set("user", userId)
+	record.Set("user_agent", userAgent)
+	return record, s.App.Save(record)
+}
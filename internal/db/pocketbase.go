@@ -2,8 +2,12 @@ package db
 
 import (
 	"dilogger/internal/push"
+	"dilogger/internal/utils"
+	"net/mail"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 	"github.com/pocketbase/pocketbase"
@@ -16,13 +20,33 @@ import (
 func NewServer() *Server {
 	godotenv.Load()
 	app := pocketbase.New()
-	notifier := push.NewNotificationApp()
 	app.OnTerminate().BindFunc(func(e *core.TerminateEvent) error {
-		// cleaning up PID file
-		os.Remove(filepath.Join(app.DataDir(), ".pid"))
+		// cleaning up PID file, unless it belongs to another running process
+		pidFile := filepath.Join(app.DataDir(), ".pid")
+		if pid, err := os.ReadFile(pidFile); err == nil && strings.TrimSpace(string(pid)) == strconv.Itoa(os.Getpid()) {
+			os.Remove(pidFile)
+		}
 		return e.Next()
 	})
-	return &Server{app, nil, nil, nil, notifier, app.Logger()}
+	server := &Server{App: app, logger: app.Logger()}
+	server.Notification = push.NewNotificationApp(app.Logger(), server.SaveWebhookDelivery)
+	server.Notification.OnResult(server.SaveNotification)
+	push.SetTemplateSource(server.MessageTemplate)
+	onesignal, err := push.NewOneSignalApp(server.OneSignalExternalIds)
+	server.Notification.AddConfigured("onesignal", onesignal, err)
+	email, err := push.NewEmail(
+		utils.GetEnv("PB_APP_NAME", "Price Logger"),
+		app.NewMailClient,
+		func() mail.Address {
+			return mail.Address{
+				Name:    app.Settings().Meta.SenderName,
+				Address: app.Settings().Meta.SenderAddress,
+			}
+		},
+		server.EmailRecipients,
+	)
+	server.Notification.AddConfigured("email", email, err)
+	return server
 }
 
 // Defines new collection
@@ -38,10 +62,37 @@ func NewCollection(name string, args ...any) *core.Collection {
 			Required: true,
 		})
 		collection.Fields.Add(&core.NumberField{
-			Name:     "stock",
+			Name:    "stock",
+			OnlyInt: true,
+		})
+		collection.Fields.Add(&core.BoolField{
+			Name: "archived",
+		})
+	case "listings":
+		productCollectionID := args[0].(string)
+		// the listings are shared by every user and recorded by the scrape runs, only superusers edit their quantity
+		collection.Fields.Add(&core.RelationField{
+			Name:          "product",
+			Required:      true,
+			CascadeDelete: true,
+			CollectionId:  productCollectionID,
+		})
+		collection.Fields.Add(&core.URLField{
+			Name:     "url",
 			Required: true,
-			OnlyInt:  true,
 		})
+		collection.Fields.Add(&core.DateField{
+			Name: "first_seen",
+		})
+		collection.Fields.Add(&core.DateField{
+			Name: "last_seen",
+		})
+		collection.Fields.Add(&core.BoolField{
+			Name: "listed",
+		})
+		collection.Fields.Add(listingQuantityField())
+		collection.AddIndex("idx_"+security.RandomString(10), true, "product, url", "")
+		collection.AddIndex("idx_"+security.RandomString(10), false, "url", "")
 	case "prices":
 		productCollectionID := args[0].(string)
 		collection.Fields.Add(&core.RelationField{
@@ -54,6 +105,370 @@ func NewCollection(name string, args ...any) *core.Collection {
 			Name:     "price",
 			Required: true,
 		})
+	case "price_daily":
+		productCollectionID := args[0].(string)
+		collection.Fields.Add(&core.RelationField{
+			Name:          "product",
+			Required:      true,
+			CascadeDelete: true,
+			CollectionId:  productCollectionID,
+		})
+		collection.Fields.Add(&core.DateField{
+			Name:     "day",
+			Required: true,
+		})
+		for _, name := range []string{"open", "high", "low", "close"} {
+			collection.Fields.Add(&core.NumberField{
+				Name:     name,
+				Required: true,
+			})
+		}
+		collection.Fields.Add(&core.NumberField{
+			Name:    "stock",
+			OnlyInt: true,
+		})
+		collection.AddIndex("idx_"+security.RandomString(10), true, "product, day", "")
+	case "webhook_deliveries":
+		collection.ListRule = nil
+		collection.ViewRule = nil
+		collection.Fields.Add(&core.URLField{
+			Name:     "url",
+			Required: true,
+		})
+		collection.Fields.Add(&core.TextField{
+			Name:     "event",
+			Required: true,
+		})
+		collection.Fields.Add(&core.TextField{
+			Name: "product",
+		})
+		collection.Fields.Add(&core.NumberField{
+			Name:    "attempt",
+			OnlyInt: true,
+		})
+		collection.Fields.Add(&core.NumberField{
+			Name:    "status",
+			OnlyInt: true,
+		})
+		collection.Fields.Add(&core.TextField{
+			Name: "error",
+		})
+		collection.Fields.Add(&core.NumberField{
+			Name: "duration",
+		})
+		collection.Fields.Add(&core.JSONField{
+			Name: "payload",
+		})
+	case "notification_log":
+		collection.ListRule = nil
+		collection.ViewRule = nil
+		collection.Fields.Add(&core.TextField{
+			Name:     "event",
+			Required: true,
+		})
+		collection.Fields.Add(&core.TextField{
+			Name: "product",
+		})
+		collection.Fields.Add(&core.TextField{
+			Name: "user",
+		})
+		collection.Fields.Add(&core.NumberField{
+			Name: "old_price",
+		})
+		collection.Fields.Add(&core.NumberField{
+			Name: "new_price",
+		})
+		collection.Fields.Add(&core.SelectField{
+			Name:     "status",
+			Required: true,
+			Values:   args[0].([]string),
+		})
+		collection.Fields.Add(&core.TextField{
+			Name: "reason",
+		})
+		collection.Fields.Add(&core.JSONField{
+			Name: "payload",
+		})
+		collection.AddIndex("idx_"+security.RandomString(10), false, "product, created", "")
+	case "notifications":
+		collection.ListRule = nil
+		collection.ViewRule = nil
+		collection.Fields.Add(&core.TextField{
+			Name:     "backend",
+			Required: true,
+		})
+		collection.Fields.Add(&core.TextField{
+			Name:     "event",
+			Required: true,
+		})
+		collection.Fields.Add(&core.TextField{
+			Name: "product",
+		})
+		collection.Fields.Add(&core.TextField{
+			Name: "recipient",
+		})
+		collection.Fields.Add(&core.SelectField{
+			Name:     "status",
+			Required: true,
+			Values:   args[0].([]string),
+		})
+		collection.Fields.Add(&core.NumberField{
+			Name:    "attempts",
+			OnlyInt: true,
+		})
+		collection.Fields.Add(&core.TextField{
+			Name: "last_error",
+		})
+		collection.Fields.Add(&core.TextField{
+			Name: "response_id",
+		})
+		collection.Fields.Add(&core.DateField{
+			Name: "next_attempt",
+		})
+		collection.Fields.Add(&core.JSONField{
+			Name:    "payload",
+			MaxSize: 1 << 20,
+		})
+		collection.AddIndex("idx_"+security.RandomString(10), false, "status, next_attempt", "")
+	case "notification_templates":
+		collection.ListRule = nil
+		collection.ViewRule = nil
+		collection.Fields.Add(&core.SelectField{
+			Name:     "channel",
+			Required: true,
+			Values:   push.MessageChannels,
+		})
+		collection.Fields.Add(&core.SelectField{
+			Name:   "event",
+			Values: push.EventTypes,
+		})
+		collection.Fields.Add(&core.TextField{
+			Name: "title",
+		})
+		collection.Fields.Add(&core.TextField{
+			Name:     "body",
+			Required: true,
+		})
+		collection.Fields.Add(&core.TextField{
+			Name: "html",
+		})
+		collection.AddIndex("idx_"+security.RandomString(10), true, "channel, event", "")
+	case "sale_events":
+		collection.Fields.Add(&core.SelectField{
+			Name:     "reason",
+			Required: true,
+			Values:   args[0].([]string),
+		})
+		collection.Fields.Add(&core.NumberField{
+			Name:    "drops",
+			OnlyInt: true,
+		})
+		collection.Fields.Add(&core.NumberField{
+			Name:    "products",
+			OnlyInt: true,
+		})
+		collection.Fields.Add(&core.JSONField{
+			Name: "urls",
+		})
+		collection.Fields.Add(&core.JSONField{
+			Name: "top_drops",
+		})
+		collection.Fields.Add(&core.BoolField{
+			Name: "notified",
+		})
+	case "push_subscriptions":
+		usersCollectionID := args[0].(string)
+		ownerRule := "user = @request.auth.id"
+		collection.ListRule = types.Pointer(ownerRule)
+		collection.ViewRule = types.Pointer(ownerRule)
+		collection.DeleteRule = types.Pointer(ownerRule)
+		collection.Fields.Add(&core.RelationField{
+			Name:          "user",
+			Required:      true,
+			CascadeDelete: true,
+			CollectionId:  usersCollectionID,
+		})
+		collection.Fields.Add(&core.URLField{
+			Name:     "endpoint",
+			Required: true,
+		})
+		collection.Fields.Add(&core.TextField{
+			Name:     "p256dh",
+			Required: true,
+			Hidden:   true,
+		})
+		collection.Fields.Add(&core.TextField{
+			Name:     "auth",
+			Required: true,
+			Hidden:   true,
+		})
+		collection.Fields.Add(&core.TextField{
+			Name: "user_agent",
+		})
+		collection.AddIndex("idx_"+security.RandomString(10), true, "endpoint", "")
+	case "onesignal_subscriptions":
+		usersCollectionID := args[0].(string)
+		ownerRule := "user = @request.auth.id"
+		collection.ListRule = types.Pointer(ownerRule)
+		collection.ViewRule = types.Pointer(ownerRule)
+		collection.DeleteRule = types.Pointer(ownerRule)
+		collection.Fields.Add(&core.RelationField{
+			Name:          "user",
+			Required:      true,
+			CascadeDelete: true,
+			CollectionId:  usersCollectionID,
+		})
+		collection.Fields.Add(&core.TextField{
+			Name:     "subscription",
+			Required: true,
+			Max:      64,
+		})
+		collection.Fields.Add(&core.TextField{
+			Name: "user_agent",
+		})
+		collection.AddIndex("idx_"+security.RandomString(10), true, "subscription", "")
+	case "alert_rules":
+		usersCollectionID := args[0].(string)
+		productCollectionID := args[1].(string)
+		ownerRule := "user = @request.auth.id"
+		collection.ListRule = types.Pointer(ownerRule)
+		collection.ViewRule = types.Pointer(ownerRule)
+		collection.CreateRule = types.Pointer("@request.auth.id != '' && user = @request.auth.id")
+		collection.UpdateRule = types.Pointer(ownerRule + " && (@request.body.user:isset = false || @request.body.user = @request.auth.id)")
+		collection.DeleteRule = types.Pointer(ownerRule)
+		collection.Fields.Add(&core.RelationField{
+			Name:          "user",
+			Required:      true,
+			CascadeDelete: true,
+			CollectionId:  usersCollectionID,
+		})
+		collection.Fields.Add(&core.RelationField{
+			Name:          "product",
+			CascadeDelete: true,
+			CollectionId:  productCollectionID,
+		})
+		collection.Fields.Add(&core.SelectField{
+			Name:     "condition",
+			Required: true,
+			Values:   args[2].([]string),
+		})
+		collection.Fields.Add(&core.NumberField{
+			Name: "threshold",
+		})
+		collection.Fields.Add(&core.TextField{
+			Name: "expression",
+			Max:  1000,
+		})
+		collection.Fields.Add(&core.SelectField{
+			Name:      "channels",
+			Required:  true,
+			MaxSelect: len(args[3].([]string)),
+			Values:    args[3].([]string),
+		})
+		collection.Fields.Add(&core.BoolField{
+			Name: "active",
+		})
+	case "purchases":
+		usersCollectionID := args[0].(string)
+		productCollectionID := args[1].(string)
+		ownerRule := "user = @request.auth.id"
+		collection.ListRule = types.Pointer(ownerRule)
+		collection.ViewRule = types.Pointer(ownerRule)
+		collection.CreateRule = types.Pointer("@request.auth.id != '' && user = @request.auth.id && @request.body.notified_price:isset = false")
+		collection.UpdateRule = types.Pointer(ownerRule + " && (@request.body.user:isset = false || @request.body.user = @request.auth.id) && @request.body.notified_price:isset = false")
+		collection.DeleteRule = types.Pointer(ownerRule)
+		collection.Fields.Add(&core.RelationField{
+			Name:          "user",
+			Required:      true,
+			CascadeDelete: true,
+			CollectionId:  usersCollectionID,
+		})
+		collection.Fields.Add(&core.RelationField{
+			Name:          "product",
+			Required:      true,
+			CascadeDelete: true,
+			CollectionId:  productCollectionID,
+		})
+		collection.Fields.Add(&core.NumberField{
+			Name:     "price",
+			Required: true,
+			Min:      types.Pointer(0.0),
+		})
+		collection.Fields.Add(&core.DateField{
+			Name:     "purchased",
+			Required: true,
+		})
+		collection.Fields.Add(&core.NumberField{
+			Name:    "protection_days",
+			OnlyInt: true,
+			Min:     types.Pointer(0.0),
+		})
+		collection.Fields.Add(&core.SelectField{
+			Name:      "channels",
+			MaxSelect: len(args[2].([]string)),
+			Values:    args[2].([]string),
+		})
+		collection.Fields.Add(&core.NumberField{
+			Name: "notified_price",
+		})
+		collection.AddIndex("idx_"+security.RandomString(10), false, "product", "")
+	case "basket_totals":
+		collection.Fields.Add(&core.URLField{
+			Name:     "url",
+			Required: true,
+		})
+		collection.Fields.Add(&core.NumberField{
+			Name: "total",
+		})
+		collection.Fields.Add(&core.NumberField{
+			Name: "in_stock_total",
+		})
+		collection.Fields.Add(&core.NumberField{
+			Name:    "items",
+			OnlyInt: true,
+		})
+		collection.Fields.Add(&core.NumberField{
+			Name:    "out_of_stock",
+			OnlyInt: true,
+		})
+		collection.AddIndex("idx_"+security.RandomString(10), false, "url, created", "")
+	case "basket_budgets":
+		usersCollectionID := args[0].(string)
+		urlCollectionID := args[1].(string)
+		ownerRule := "user = @request.auth.id"
+		collection.ListRule = types.Pointer(ownerRule)
+		collection.ViewRule = types.Pointer(ownerRule)
+		collection.CreateRule = types.Pointer("@request.auth.id != '' && user = @request.auth.id && @request.body.notified:isset = false")
+		collection.UpdateRule = types.Pointer(ownerRule + " && (@request.body.user:isset = false || @request.body.user = @request.auth.id) && @request.body.notified:isset = false")
+		collection.DeleteRule = types.Pointer(ownerRule)
+		collection.Fields.Add(&core.RelationField{
+			Name:          "user",
+			Required:      true,
+			CascadeDelete: true,
+			CollectionId:  usersCollectionID,
+		})
+		collection.Fields.Add(&core.RelationField{
+			Name:          "wishlist",
+			Required:      true,
+			CascadeDelete: true,
+			CollectionId:  urlCollectionID,
+		})
+		collection.Fields.Add(&core.NumberField{
+			Name:     "target",
+			Required: true,
+			Min:      types.Pointer(0.0),
+		})
+		collection.Fields.Add(&core.BoolField{
+			Name: "in_stock_only",
+		})
+		collection.Fields.Add(&core.SelectField{
+			Name:      "channels",
+			MaxSelect: len(args[2].([]string)),
+			Values:    args[2].([]string),
+		})
+		collection.Fields.Add(&core.BoolField{
+			Name: "notified",
+		})
 	case "urls":
 		accessRule := "@request.auth.id != ''"
 		collection.CreateRule = types.Pointer(accessRule)
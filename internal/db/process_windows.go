@@ -0,0 +1,25 @@
+package db
+
+import (
+	"errors"
+
+	"golang.org/x/sys/windows"
+)
+
+// Exit code of a process which is still running
+const stillActive = 259
+
+// Check whether a process with the pid is still running, since Windows cannot signal a process to probe it
+func processAlive(pid int) bool {
+	handle, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		// a process of another user cannot be opened, it counts as alive
+		return errors.Is(err, windows.ERROR_ACCESS_DENIED)
+	}
+	defer windows.CloseHandle(handle)
+	var code uint32
+	if err := windows.GetExitCodeProcess(handle, &code); err != nil {
+		return false
+	}
+	return code == stillActive
+}
@@ -0,0 +1,176 @@
+package db
+
+import (
+	"dilogger/internal/model"
+	"dilogger/internal/push"
+	"dilogger/internal/series"
+	"dilogger/internal/utils"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Channels of the price protection and budget alerts, only the backends delivering to a single user
+var UserChannels = []string{"email", "webpush", "onesignal"}
+
+// Targets of an alert sent to a single user on the selected channels, every user channel when none is selected
+func userChannelTargets(user string, channels []string) map[string][]string {
+	if len(channels) == 0 {
+		channels = UserChannels
+	}
+	targets := map[string][]string{}
+	for _, channel := range channels {
+		targets[channel] = []string{user}
+	}
+	return targets
+}
+
+// Create new purchase collection in database
+func (s *Server) NewPurchaseCollection() {
+	if _, err := s.App.FindCollectionByNameOrId("purchases"); err == nil {
+		return
+	}
+	users, err := s.App.FindCollectionByNameOrId("users")
+	if err != nil {
+		s.logger.Error(err.Error())
+		return
+	}
+	products, err := s.App.FindCollectionByNameOrId("products")
+	if err != nil {
+		s.logger.Error(err.Error())
+		return
+	}
+	if err := s.App.Save(NewCollection("purchases", users.Id, products.Id, UserChannels)); err != nil {
+		s.logger.Error(err.Error())
+	}
+}
+
+// Validate the price and date of purchases when they are saved
+func (s *Server) PurchaseHook() {
+	s.App.OnRecordValidate("purchases").BindFunc(func(e *core.RecordEvent) error {
+		if e.Record.GetFloat("price") <= 0 {
+			return validation.Errors{"price": validation.NewError("validation_invalid_price", "price must be greater than 0")}
+		}
+		if e.Record.GetDateTime("purchased").Time().After(time.Now()) {
+			return validation.Errors{"purchased": validation.NewError("validation_invalid_purchased", "purchase date must not be in the future")}
+		}
+		return e.Next()
+	})
+}
+
+// End of the price protection window of a purchase, PRICE_PROTECTION_DAYS after the purchase unless the purchase sets its own window
+func protectedUntil(record *core.Record) time.Time {
+	days := record.GetInt("protection_days")
+	if days == 0 {
+		days = utils.GetEnvInt("PRICE_PROTECTION_DAYS", 30)
+	}
+	return record.GetDateTime("purchased").Time().AddDate(0, 0, days)
+}
+
+// The `CheckPriceProtection` function alerts the buyers of a product whose price dropped below the price they paid,
+// while their purchase is in its protection window. A purchase is alerted again only when the price drops further.
+func (s *Server) CheckPriceProtection(product model.Product) {
+	records, err := s.App.FindAllRecords("purchases",
+		dbx.HashExp{"product": product.Id},
+		dbx.NewExp("price > {:price}", dbx.Params{"price": product.Price}),
+	)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return
+	}
+	now := time.Now()
+	for _, record := range records {
+		until := protectedUntil(record)
+		if now.After(until) {
+			continue
+		}
+		if notified := record.GetFloat("notified_price"); notified > 0 && product.Price >= notified {
+			continue
+		}
+		// price protection alerts are only sent when the price drops further, the throttling only holds them in quiet hours
+		event := push.Event{
+			Type:           push.PriceProtection,
+			Product:        product,
+			OldPrice:       record.GetFloat("price"),
+			NewPrice:       product.Price,
+			Time:           now,
+			Targets:        userChannelTargets(record.GetString("user"), record.GetStringSlice("channels")),
+			ProtectedUntil: &until,
+		}
+		if event, ok := s.Throttle(event); ok {
+			s.Notification.Send(event)
+			s.LogSentNotification(event)
+		}
+		record.Set("notified_price", product.Price)
+		if err := s.App.Save(record); err != nil {
+			s.logger.Error(err.Error())
+		}
+	}
+}
+
+// The `PurchaseSavings` function compares the prices paid by a user with the average price of the products in the 90 days before each purchase.
+func (s *Server) PurchaseSavings(userId string) (model.SavingsReport, error) {
+	report := model.SavingsReport{Purchases: []model.PurchaseSavings{}}
+	records, err := s.App.FindRecordsByFilter("purchases", "user = {:user}", "-purchased", 0, 0, dbx.Params{"user": userId})
+	if err != nil {
+		return report, err
+	}
+	if len(records) == 0 {
+		return report, nil
+	}
+	s.App.ExpandRecords(records, []string{"product"}, nil)
+	var productIds []string
+	for _, record := range records {
+		productIds = append(productIds, record.GetString("product"))
+	}
+	stats, err := s.ProductStats(productIds...)
+	if err != nil {
+		return report, err
+	}
+	productStats := map[string]model.ProductStats{}
+	for _, stat := range stats {
+		productStats[stat.ProductId] = stat
+	}
+	for _, record := range records {
+		stat := productStats[record.GetString("product")]
+		savings := model.PurchaseSavings{
+			PurchaseId: record.Id,
+			ProductId:  record.GetString("product"),
+			Paid:       record.GetFloat("price"),
+			Purchased:  record.GetDateTime("purchased").Time(),
+			Current:    stat.Current,
+		}
+		savings.Average, err = s.averagePrice(savings.ProductId, savings.Purchased)
+		if err != nil {
+			return report, err
+		}
+		if product := record.ExpandedOne("product"); product != nil {
+			savings.Name = product.GetString("name")
+		}
+		if savings.Average > 0 {
+			savings.Saved = savings.Average - savings.Paid
+			savings.SavedPercent = savings.Saved / savings.Average * 100
+			report.Compared++
+			report.Paid += savings.Paid
+			report.Average += savings.Average
+			report.Saved += savings.Saved
+		}
+		report.Purchases = append(report.Purchases, savings)
+	}
+	if report.Average > 0 {
+		report.SavedPercent = report.Saved / report.Average * 100
+	}
+	return report, nil
+}
+
+// Time weighted average price of a product in the 90 days before t, zero when no price was seen
+func (s *Server) averagePrice(productId string, t time.Time) (float64, error) {
+	from := t.AddDate(0, 0, -90)
+	intervals, err := s.GetPriceIntervals(productId, from, t)
+	if err != nil {
+		return 0, err
+	}
+	return series.StatsAt(series.Points(intervals, from, t), t).Last90Days.Avg, nil
+}
@@ -0,0 +1,112 @@
+package db
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/dbx"
+)
+
+// Retention rule of a collection, records older than Days are deleted and zero keeps them forever
+type RetentionRule struct {
+	Collection string
+	Days       int
+}
+
+// Retention report of a single rule
+type RetentionReport struct {
+	Collection string    `json:"collection"`
+	Cutoff     time.Time `json:"cutoff"`
+	Matched    int64     `json:"matched"`
+	DryRun     bool      `json:"dry_run"`
+}
+
+// Parse retention rules written as "prices=180d,price_daily=forever,logs=7d"
+func ParseRetentionRules(value string) ([]RetentionRule, error) {
+	var rules []RetentionRule
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, age, found := strings.Cut(entry, "=")
+		if !found || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("invalid retention rule '%s': expected collection=age", entry)
+		}
+		rule := RetentionRule{Collection: strings.TrimSpace(name)}
+		age = strings.TrimSpace(age)
+		if age != "forever" {
+			days, err := strconv.Atoi(strings.TrimSuffix(age, "d"))
+			if err != nil || days < 0 {
+				return nil, fmt.Errorf("invalid retention age '%s': expected days like 30d or forever", age)
+			}
+			rule.Days = days
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// Get the retention days of a collection from the rules or the fallback when there is no rule
+func RetentionDays(rules []RetentionRule, collection string, fallback int) int {
+	for _, rule := range rules {
+		if rule.Collection == collection {
+			return rule.Days
+		}
+	}
+	return fallback
+}
+
+// Apply retention rules to their collections and vacuum the database afterwards.
+// The logs rule is handled by the log settings, raw prices are only pruned once rolled up.
+func (s *Server) ApplyRetention(rules []RetentionRule, dryRun bool) ([]RetentionReport, error) {
+	var reports []RetentionReport
+	for _, rule := range rules {
+		if rule.Days == 0 || rule.Collection == "logs" {
+			continue
+		}
+		report := RetentionReport{
+			Collection: rule.Collection,
+			Cutoff:     time.Now().AddDate(0, 0, -rule.Days),
+			DryRun:     dryRun,
+		}
+		var err error
+		if rule.Collection == "prices" {
+			report.Cutoff, report.Matched, err = s.PruneRawPrices(report.Cutoff, dryRun)
+		} else {
+			report.Matched, err = s.pruneRecords(rule.Collection, report.Cutoff, dryRun)
+		}
+		if err != nil {
+			return reports, fmt.Errorf("retention of %s: %w", rule.Collection, err)
+		}
+		reports = append(reports, report)
+	}
+	if !dryRun {
+		if err := s.App.Vacuum(); err != nil {
+			return reports, err
+		}
+	}
+	return reports, nil
+}
+
+// Delete records of a collection created before the cutoff
+func (s *Server) pruneRecords(collection string, cutoff time.Time, dryRun bool) (int64, error) {
+	params := dbx.Params{"cutoff": FormatDate(cutoff)}
+	if dryRun {
+		return s.App.CountRecords(collection, dbx.NewExp("created < {:cutoff}", params))
+	}
+	records, err := s.App.FindRecordsByFilter(collection, "created < {:cutoff}", "", 0, 0, params)
+	if err != nil {
+		return 0, err
+	}
+	var deleted int64
+	for _, record := range records {
+		if err := s.App.Delete(record); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
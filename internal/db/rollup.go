@@ -0,0 +1,134 @@
+package db
+
+import (
+	"dilogger/internal/model"
+	"dilogger/internal/series"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Minimum age of raw prices that can be pruned, the statistics windows need the last 90 days
+const minRawRetention = 90 * 24 * time.Hour
+
+// Roll up raw prices of every product into daily OHLC records, starting from the last rolled up day
+func (s *Server) RollupDailyPrices() {
+	if s.dailyCollection == nil {
+		s.NewPriceDailyCollection()
+	}
+	products, err := s.App.FindAllRecords("products")
+	if err != nil {
+		s.logger.Error(err.Error())
+		return
+	}
+	now := time.Now().UTC()
+	for _, product := range products {
+		from := time.Unix(0, 0).UTC()
+		last, err := s.App.FindRecordsByFilter(
+			s.dailyCollection,
+			"product = {:product}",
+			"-day", 1, 0,
+			dbx.Params{"product": product.Id},
+		)
+		if err == nil && len(last) > 0 {
+			// the last rolled up day may have been partial, so it is computed again
+			from = last[0].GetDateTime("day").Time()
+		}
+		intervals, err := s.GetPriceIntervals(product.Id, from, now)
+		if err != nil {
+			s.logger.Error(err.Error())
+			continue
+		}
+		for _, point := range series.Fill(intervals, series.Day, from, now) {
+			if err := s.saveDailyPrice(product, point, now); err != nil {
+				s.logger.Error(err.Error())
+			}
+		}
+	}
+}
+
+// Create or update the daily record of a product
+func (s *Server) saveDailyPrice(product *core.Record, point model.PricePoint, now time.Time) error {
+	record, err := s.App.FindFirstRecordByFilter(
+		s.dailyCollection,
+		"product = {:product} && day = {:day}",
+		dbx.Params{"product": product.Id, "day": FormatDate(point.Time)},
+	)
+	if err != nil {
+		record = core.NewRecord(s.dailyCollection)
+		record.Set("product", product.Id)
+		record.Set("day", point.Time)
+	}
+	record.Set("open", point.Open)
+	record.Set("high", point.High)
+	record.Set("low", point.Low)
+	record.Set("close", point.Price)
+	if record.IsNew() || series.Day.Start(now).Equal(point.Time) {
+		// stock is only known at the time of the rollup
+		record.Set("stock", product.GetInt("stock"))
+	}
+	return s.App.Save(record)
+}
+
+// Get daily OHLC points of a product between from and to
+func (s *Server) GetDailyPrices(productId string, from, to time.Time) ([]model.PricePoint, error) {
+	records, err := s.App.FindRecordsByFilter(
+		"price_daily",
+		"product = {:product} && day >= {:from} && day <= {:to}",
+		"day", 0, 0,
+		dbx.Params{
+			"product": productId,
+			"from":    FormatDate(series.Day.Start(from)),
+			"to":      FormatDate(to),
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	points := []model.PricePoint{}
+	for _, record := range records {
+		points = append(points, model.PricePoint{
+			Time:  record.GetDateTime("day").Time(),
+			Price: record.GetFloat("close"),
+			Open:  record.GetFloat("open"),
+			High:  record.GetFloat("high"),
+			Low:   record.GetFloat("low"),
+		})
+	}
+	return points, nil
+}
+
+// Condition matching raw prices last seen before the cutoff whose day is already rolled up.
+// The latest, lowest and highest price of each product never match so the statistics stay correct.
+const prunablePrices = `
+	updated < {:cutoff}
+	AND EXISTS (SELECT 1 FROM price_daily d WHERE d.product = prices.product AND date(d.day) > date(prices.updated))
+	AND id NOT IN (
+		SELECT id FROM (
+			SELECT id,
+				ROW_NUMBER() OVER (PARTITION BY product ORDER BY updated DESC) AS recent,
+				ROW_NUMBER() OVER (PARTITION BY product ORDER BY price ASC, updated DESC) AS low,
+				ROW_NUMBER() OVER (PARTITION BY product ORDER BY price DESC, updated DESC) AS high
+			FROM prices
+		) WHERE recent = 1 OR low = 1 OR high = 1
+	)`
+
+// Delete or count the prunable raw prices, the cutoff is never less than 90 days ago
+func (s *Server) PruneRawPrices(cutoff time.Time, dryRun bool) (time.Time, int64, error) {
+	if time.Since(cutoff) < minRawRetention {
+		cutoff = time.Now().Add(-minRawRetention)
+	}
+	params := dbx.Params{"cutoff": FormatDate(cutoff)}
+	if dryRun {
+		var count int64
+		err := s.App.DB().NewQuery("SELECT COUNT(*) FROM prices WHERE " + prunablePrices).Bind(params).Row(&count)
+		return cutoff, count, err
+	}
+	result, err := s.App.DB().NewQuery("DELETE FROM prices WHERE " + prunablePrices).Bind(params).Execute()
+	if err != nil {
+		return cutoff, 0, err
+	}
+	count, err := result.RowsAffected()
+	return cutoff, count, err
+}
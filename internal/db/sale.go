@@ -0,0 +1,186 @@
+package db
+
+import (
+	"dilogger/internal/alert"
+	"dilogger/internal/push"
+	"dilogger/internal/utils"
+	"maps"
+	"slices"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// ScrapeRun collects the products and price drops of a scrape run to detect sales and wishlist changes
+type ScrapeRun struct {
+	// products lists the ids of the products scraped from each URL
+	products map[string][]string
+	// partial holds the URLs whose scrape or save failed, their listings are not updated
+	partial map[string]bool
+	drops   []alert.Input
+}
+
+// Create new sale event collection in database
+func (s *Server) NewSaleEventCollection() {
+	if _, err := s.App.FindCollectionByNameOrId("sale_events"); err == nil {
+		return
+	}
+	if err := s.App.Save(NewCollection("sale_events", alert.SaleReasons)); err != nil {
+		s.logger.Error(err.Error())
+	}
+}
+
+// Start collecting the products and price drops of a scrape run, they are handled by FinishRun.
+// Runs do not overlap: ok is false while another run is in progress.
+func (s *Server) StartRun() (run *ScrapeRun, ok bool) {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+	if s.run != nil {
+		return nil, false
+	}
+	s.run = &ScrapeRun{products: map[string][]string{}, partial: map[string]bool{}}
+	return s.run, true
+}
+
+// The `FinishRun` function ends a run started by StartRun, it updates the wishlist listings and basket totals with the products of the run and sends the price drops collected since StartRun.
+// When they make a sale, a sale event is recorded and a single alert listing the largest SALE_TOP_DROPS drops is sent instead of the drops,
+// each targeted user getting the largest of the drops matching their alert rules. The sale alerts are throttled like the other alerts.
+func (s *Server) FinishRun(run *ScrapeRun) {
+	s.runMu.Lock()
+	if run == nil || s.run != run {
+		s.runMu.Unlock()
+		return
+	}
+	s.run = nil
+	s.runMu.Unlock()
+	complete := maps.Clone(run.products)
+	for url := range run.partial {
+		s.logger.Warn("listings not updated after a partial scrape", "url", url)
+		delete(complete, url)
+	}
+	s.UpdateListings(complete)
+	s.UpdateBasketTotals(slices.Sorted(maps.Keys(complete)))
+	if len(run.drops) == 0 {
+		return
+	}
+	drops := make([]push.Event, len(run.drops))
+	for i, input := range run.drops {
+		drops[i] = input.Event
+	}
+	reason, urls := alert.DetectSale(drops, run.products, alert.SaleThresholds{
+		MinProducts: utils.GetEnvInt("SALE_MIN_PRODUCTS", 10),
+		MinPercent:  float64(utils.GetEnvInt("SALE_MIN_PERCENT", 50)),
+	})
+	if reason == "" {
+		for _, input := range run.drops {
+			s.SendAlert(input)
+		}
+		return
+	}
+	for i, input := range run.drops {
+		event, ok := s.alertTargets(input)
+		if !ok {
+			// nobody is notified about this drop, but it is still listed
+			event.Targets = map[string][]string{}
+		}
+		drops[i] = event
+		s.logNotification(input.Event, "", NotificationSuppressed, "sale")
+	}
+	top := utils.GetEnvInt("SALE_TOP_DROPS", 5)
+	sale := push.SaleEvent(drops, top)
+	notified := false
+	for _, event := range saleEvents(sale, drops, top) {
+		event, ok := s.Throttle(event)
+		if !ok {
+			continue
+		}
+		s.Notification.Send(event)
+		s.LogSentNotification(event)
+		notified = true
+	}
+	s.saveSaleEvent(reason, urls, sale, notified)
+	s.logger.Info("sale detected", "reason", reason, "drops", sale.DropCount, "notified", notified)
+}
+
+// Split a sale into the events to send: the whole sale for the backends reaching every subscriber,
+// and for each targeted user a sale listing only the drops which notify that user
+func saleEvents(sale push.Event, drops []push.Event, top int) []push.Event {
+	if sale.Targets == nil {
+		return []push.Event{sale}
+	}
+	var events []push.Event
+	shared := sale
+	shared.Targets = map[string][]string{}
+	for backend, users := range sale.Targets {
+		if slices.Contains(users, push.AllSubscribers) {
+			shared.Targets[backend] = []string{push.AllSubscribers}
+		}
+	}
+	if len(shared.Targets) > 0 {
+		events = append(events, shared)
+	}
+	for _, user := range targetedUsers(sale) {
+		var userDrops []push.Event
+		for _, drop := range drops {
+			if targets := userTargets(drop.Targets, user); len(targets) > 0 {
+				drop.Targets = targets
+				userDrops = append(userDrops, drop)
+			}
+		}
+		events = append(events, push.SaleEvent(userDrops, top))
+	}
+	return events
+}
+
+// Collect a price drop when a run is started, ok is false when there is no run
+func (s *Server) collectDrop(input alert.Input) bool {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+	if s.run == nil {
+		return false
+	}
+	s.run.drops = append(s.run.drops, input)
+	return true
+}
+
+// Add a product scraped from a URL to the run, if any
+func (s *Server) addRunProduct(url string, productId string) {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+	if s.run == nil || url == "" || slices.Contains(s.run.products[url], productId) {
+		return
+	}
+	s.run.products[url] = append(s.run.products[url], productId)
+}
+
+// Skip the listings and basket total of a URL in the run, if any, when its scrape or save was partial
+func (s *Server) SkipRunListings(url string) {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+	if s.run == nil || url == "" {
+		return
+	}
+	s.run.partial[url] = true
+}
+
+// Save a detected sale into the sale events
+func (s *Server) saveSaleEvent(reason string, urls []alert.UrlDrops, sale push.Event, notified bool) {
+	collection, err := s.App.FindCachedCollectionByNameOrId("sale_events")
+	if err != nil {
+		s.logger.Error(err.Error())
+		return
+	}
+	products := 0
+	for _, url := range urls {
+		products += url.Products
+	}
+	record := core.NewRecord(collection)
+	record.Set("reason", reason)
+	record.Set("drops", sale.DropCount)
+	record.Set("products", products)
+	record.Set("urls", urls)
+	record.Set("top_drops", sale.Drops)
+	record.Set("notified", notified)
+	if err := s.App.Save(record); err != nil {
+		s.logger.Error(err.Error())
+	}
+}
@@ -1,15 +1,20 @@
 package db
 
 import (
+	"dilogger/internal/alert"
 	"dilogger/internal/model"
 	"dilogger/internal/push"
 	"log/slog"
+	"slices"
+	"sync"
 
 	"github.com/pocketbase/dbx"
 	"github.com/pocketbase/pocketbase"
 	"github.com/pocketbase/pocketbase/core"
 	"github.com/pocketbase/pocketbase/tools/cron"
 	"github.com/pocketbase/pocketbase/tools/hook"
+	"github.com/pocketbase/pocketbase/tools/list"
+	"github.com/pocketbase/pocketbase/tools/types"
 	"github.com/spf13/cobra"
 )
 
@@ -17,9 +22,13 @@ type Server struct {
 	App               *pocketbase.PocketBase
 	productCollection *core.Collection
 	priceCollection   *core.Collection
+	dailyCollection   *core.Collection
 	urlCollection     *core.Collection
-	Notification      *push.OneSignalApp
+	Notification      *push.Dispatcher
 	logger            *slog.Logger
+	runMu             sync.Mutex
+	run               *ScrapeRun
+	retryMu           sync.Mutex
 }
 
 // Cobra's AddCommand function extended
@@ -65,6 +74,23 @@ func (s *Server) NewProductCollection() {
 	}
 	collection, err := s.App.FindCollectionByNameOrId("products")
 	if err == nil {
+		changed := false
+		// a required number field rejects 0, so out of stock products could not be saved
+		if stock, ok := collection.Fields.GetByName("stock").(*core.NumberField); ok && stock.Required {
+			stock.Required = false
+			changed = true
+		}
+		if collection.Fields.GetByName("archived") == nil {
+			collection.Fields.Add(&core.BoolField{
+				Name: "archived",
+			})
+			changed = true
+		}
+		if changed {
+			if err := s.App.Save(collection); err != nil {
+				s.logger.Error(err.Error())
+			}
+		}
 		s.productCollection = collection
 		return
 	}
@@ -96,6 +122,195 @@ func (s *Server) NewPriceCollection() {
 	s.priceCollection = collection
 }
 
+// Create new daily Price Collection in database
+func (s *Server) NewPriceDailyCollection() {
+	if s.productCollection == nil {
+		s.NewProductCollection()
+	}
+	collection, err := s.App.FindCollectionByNameOrId("price_daily")
+	if err == nil {
+		s.dailyCollection = collection
+		return
+	}
+	collection = NewCollection("price_daily", s.productCollection.Id)
+	err = s.App.Save(collection)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return
+	}
+	s.dailyCollection = collection
+}
+
+// Create all collections missing in database
+func (s *Server) InitCollections() {
+	s.NewUrlCollection()
+	s.NewProductCollection()
+	s.NewPriceCollection()
+	s.NewPriceDailyCollection()
+	s.NewLogCollection("webhook_deliveries")
+	s.AddUserFields()
+	s.NewPushSubscriptionCollection()
+	s.NewOneSignalSubscriptionCollection()
+	s.NewAlertRuleCollection()
+	s.NewNotificationLogCollection()
+	s.NewNotificationCollection()
+	s.NewNotificationTemplateCollection()
+	s.NewSaleEventCollection()
+	s.NewPurchaseCollection()
+	s.NewListingCollection()
+	s.NewBasketCollections()
+}
+
+// Add the notification preferences missing from the users collection, and the select values changed since they were added
+func (s *Server) AddUserFields() {
+	collection, err := s.App.FindCollectionByNameOrId("users")
+	if err != nil {
+		s.logger.Error(err.Error())
+		return
+	}
+	fields := []core.Field{
+		&core.BoolField{
+			Name: "email_alerts",
+		},
+		&core.TextField{
+			Name: "timezone",
+			Max:  64,
+		},
+		&core.TextField{
+			Name: "quiet_hours",
+			Max:  11,
+		},
+		&core.NumberField{
+			Name:    "alert_cooldown",
+			OnlyInt: true,
+			Min:     types.Pointer(0.0),
+		},
+		&core.SelectField{
+			Name:      "digest",
+			MaxSelect: 1,
+			Values:    []string{push.Daily, push.Weekly},
+		},
+		&core.TextField{
+			Name: "digest_time",
+			Max:  5,
+		},
+		&core.SelectField{
+			Name:      "digest_day",
+			MaxSelect: 1,
+			Values:    alert.Weekdays,
+		},
+		&core.SelectField{
+			Name:      "digest_channels",
+			MaxSelect: len(UserChannels),
+			Values:    UserChannels,
+		},
+	}
+	changed := false
+	for _, field := range fields {
+		existing := collection.Fields.GetByName(field.GetName())
+		if existing == nil {
+			collection.Fields.Add(field)
+			changed = true
+			continue
+		}
+		selectField, ok := field.(*core.SelectField)
+		if existingSelect, isSelect := existing.(*core.SelectField); ok && isSelect && !slices.Equal(existingSelect.Values, selectField.Values) {
+			existingSelect.Values = selectField.Values
+			existingSelect.MaxSelect = selectField.MaxSelect
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+	if err := s.App.Save(collection); err != nil {
+		s.logger.Error(err.Error())
+	}
+}
+
+// Get the users targeted by the alert rules and, when the event reaches every subscriber, the users subscribed to email alerts without an email alert rule
+func (s *Server) EmailRecipients(event push.Event) ([]push.Recipient, error) {
+	users, all := event.Users("email")
+	exps := []dbx.Expression{dbx.In("id", list.ToInterfaceSlice(users)...)}
+	if all {
+		exps = append(exps, dbx.And(dbx.HashExp{"email_alerts": true}, withoutAlertRule("users.id", "email")))
+	}
+	records, err := s.App.FindAllRecords("users", dbx.Or(exps...))
+	if err != nil {
+		return nil, err
+	}
+	var recipients []push.Recipient
+	for _, record := range records {
+		recipients = append(recipients, push.Recipient{
+			Id:    record.Id,
+			Name:  record.GetString("name"),
+			Email: record.Email(),
+		})
+	}
+	return recipients, nil
+}
+
+// The `OneSignalExternalIds` function returns the OneSignal external ids of the users, which are their user ids.
+// Only the users who linked at least one OneSignal subscription by logging in through the web UI are returned.
+// With all, the users without a OneSignal alert rule are added, or segments is true when nobody has one.
+func (s *Server) OneSignalExternalIds(users []string, all bool) ([]string, bool, error) {
+	exps := []dbx.Expression{dbx.In("id", list.ToInterfaceSlice(users)...)}
+	if all {
+		ruled, err := s.ruledChannels()
+		if err != nil {
+			return nil, false, err
+		}
+		if !ruled["onesignal"] {
+			return nil, true, nil
+		}
+		exps = append(exps, withoutAlertRule("users.id", "onesignal"))
+	}
+	records, err := s.App.FindAllRecords("users",
+		dbx.Or(exps...),
+		dbx.NewExp("EXISTS (SELECT 1 FROM onesignal_subscriptions o WHERE o.user = users.id)"),
+	)
+	if err != nil {
+		return nil, false, err
+	}
+	var externalIds []string
+	for _, record := range records {
+		externalIds = append(externalIds, record.Id)
+	}
+	return externalIds, false, nil
+}
+
+// Create new log collection in database, log records are only visible to superusers
+func (s *Server) NewLogCollection(name string) {
+	if _, err := s.App.FindCollectionByNameOrId(name); err == nil {
+		return
+	}
+	err := s.App.Save(NewCollection(name))
+	if err != nil {
+		s.logger.Error(err.Error())
+	}
+}
+
+// Save a webhook delivery attempt into the delivery log
+func (s *Server) SaveWebhookDelivery(delivery push.Delivery) {
+	collection, err := s.App.FindCachedCollectionByNameOrId("webhook_deliveries")
+	if err != nil {
+		s.logger.Error(err.Error())
+		return
+	}
+	record := core.NewRecord(collection)
+	record.Set("url", delivery.URL)
+	record.Set("event", delivery.Event.Type)
+	record.Set("product", delivery.Event.Product.Id)
+	record.Set("attempt", delivery.Attempt)
+	record.Set("status", delivery.StatusCode)
+	record.Set("error", delivery.Error)
+	record.Set("duration", delivery.Duration.Milliseconds())
+	record.Set("payload", delivery.Event)
+	if err := s.App.Save(record); err != nil {
+		s.logger.Error(err.Error())
+	}
+}
+
 // Get list of URLs from url database
 func (s *Server) GetURLs() []string {
 	var urls []string
@@ -131,17 +346,20 @@ func (s *Server) PriceMatch(product model.Product) (*core.Record, *core.Record,
 	return productRecord, records[0], true
 }
 
-// Add product data to the database
+// Add product data to the database, a product which cannot be saved is skipped and the listings of its URL are left as they were
 func (s *Server) AddToCollection(products []model.Product) {
 	if s.priceCollection == nil || s.productCollection == nil {
 		s.NewPriceCollection()
 	}
 	for _, product := range products {
 		productRecord, record, matched := s.PriceMatch(product)
-		if matched {
-			if stock := int32(productRecord.Get("stock").(float64)); stock != product.Stock {
-				productRecord.Set("stock", product.Stock)
+		if productRecord != nil && int32(productRecord.GetInt("stock")) != product.Stock {
+			productRecord.Set("stock", product.Stock)
+			if err := s.App.Save(productRecord); err != nil {
+				s.logger.Error(err.Error())
 			}
+		}
+		if matched {
 			record.Set("price", product.Price)
 		} else {
 			if productRecord == nil {
@@ -151,23 +369,26 @@ func (s *Server) AddToCollection(products []model.Product) {
 				err := s.App.Save(productRecord)
 				if err != nil {
 					s.logger.Error(err.Error())
-					return
+					s.SkipRunListings(product.Url)
+					continue
 				}
 			}
 			record = core.NewRecord(s.priceCollection)
 			record.Set("product", productRecord.Id)
 			record.Set("price", product.Price)
 		}
+		s.addRunProduct(product.Url, productRecord.Id)
 		err := s.App.Save(record)
 		if err != nil {
 			s.logger.Error(err.Error())
-			return
+			s.SkipRunListings(product.Url)
 		}
 	}
 }
 
-// Update price when the hook is triggered
-func (s *Server) PriceUpdateHook(bindingFunction func(e *core.RecordEvent) error) {
+// Trigger the binding function when a new price of a product is created or an older price of a product is seen again.
+// The repeats of a price flapping between two values are suppressed by Throttle.
+func (s *Server) PriceUpdateHook(bindingFunction func(record *core.Record) error) {
 	s.App.OnRecordCreate("prices").BindFunc(func(e *core.RecordEvent) error {
 		price := e.Record.GetFloat("price")
 		existingRecords, err := s.App.FindRecordsByFilter(
@@ -192,13 +413,48 @@ func (s *Server) PriceUpdateHook(bindingFunction func(e *core.RecordEvent) error
 		}
 		return e.Next()
 	})
-	s.App.OnRecordAfterCreateSuccess("prices").BindFunc(bindingFunction)
+	s.App.OnRecordAfterCreateSuccess("prices").BindFunc(func(e *core.RecordEvent) error {
+		if err := bindingFunction(e.Record); err != nil {
+			return err
+		}
+		return e.Next()
+	})
+	// a revived price record is updated instead of created, it is a change unless it was already the latest price
+	s.App.OnRecordUpdate("prices").BindFunc(func(e *core.RecordEvent) error {
+		latest, err := s.App.FindRecordsByFilter(
+			"prices",
+			"product = {:product}",
+			"-updated", 1, 0,
+			dbx.Params{"product": e.Record.GetString("product")},
+		)
+		if err != nil {
+			return err
+		}
+		if err := e.Next(); err != nil {
+			return err
+		}
+		if len(latest) == 0 || latest[0].Id == e.Record.Id {
+			return nil
+		}
+		return bindingFunction(e.Record)
+	})
+}
+
+// Trigger the binding function once the stock of a product was updated, with the previous stock
+func (s *Server) StockUpdateHook(bindingFunction func(record *core.Record, oldStock int) error) {
+	s.App.OnRecordAfterUpdateSuccess("products").BindFunc(func(e *core.RecordEvent) error {
+		if oldStock := e.Record.Original().GetInt("stock"); oldStock != e.Record.GetInt("stock") {
+			if err := bindingFunction(e.Record, oldStock); err != nil {
+				return err
+			}
+		}
+		return e.Next()
+	})
 }
 
 // Create Product object from product record
 func (s *Server) GetProduct(priceRecord *core.Record) model.Product {
 	var product model.Product
-	product.Id = priceRecord.Id
 	product.Price = priceRecord.GetFloat("price")
 	product.CreatedAt = priceRecord.GetDateTime("created").Time()
 	product.UpdatedAt = priceRecord.GetDateTime("updated").Time()
@@ -207,11 +463,29 @@ func (s *Server) GetProduct(priceRecord *core.Record) model.Product {
 	if record == nil {
 		return model.Product{}
 	}
+	product.Id = record.Id
 	product.Name = record.GetString("name")
 	product.Stock = int32(record.GetInt("stock"))
 	return product
 }
 
+// Get the price seen before the given price record of a product
+func (s *Server) PreviousPrice(priceRecord *core.Record) float64 {
+	records, err := s.App.FindRecordsByFilter(
+		"prices",
+		"product = {:product} && id != {:id}",
+		"-updated", 1, 0,
+		dbx.Params{
+			"product": priceRecord.GetString("product"),
+			"id":      priceRecord.Id,
+		},
+	)
+	if err != nil || len(records) < 1 {
+		return 0
+	}
+	return records[0].GetFloat("price")
+}
+
 // Count the number of records with same product id inside prices collection
 func (s *Server) CountPriceRecords(productId string) int64 {
 	count, err := s.App.CountRecords("prices", dbx.HashExp{"product": productId})
@@ -0,0 +1,156 @@
+package db
+
+import (
+	"dilogger/internal/model"
+	"dilogger/internal/series"
+	"dilogger/internal/utils"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Query to aggregate price statistics per product, the placeholder is the filter.
+// A revived record is seen at its creation and again at its last update, so the changes are counted
+// over both observations of every record.
+const statsQuery = `
+WITH seen AS (
+	SELECT product, price, created AS time FROM prices %[1]s
+	UNION ALL
+	SELECT product, price, updated AS time FROM prices %[1]s
+),
+c AS (
+	SELECT product, SUM(changed) AS changes FROM (
+		SELECT product, COALESCE(price <> LAG(price) OVER (PARTITION BY product ORDER BY time), 0) AS changed FROM seen
+	) GROUP BY product
+),
+p AS (
+	SELECT product, price, created, updated,
+		ROW_NUMBER() OVER (PARTITION BY product ORDER BY updated DESC, created DESC) AS recent,
+		ROW_NUMBER() OVER (PARTITION BY product ORDER BY price ASC, updated DESC) AS low,
+		ROW_NUMBER() OVER (PARTITION BY product ORDER BY price DESC, updated DESC) AS high
+	FROM prices
+	%[1]s
+)
+SELECT
+	product,
+	MAX(CASE WHEN recent = 1 THEN price END) AS current,
+	MAX(CASE WHEN recent = 1 THEN created END) AS current_since,
+	MAX(CASE WHEN recent > 1 THEN updated END) AS previous_seen,
+	MAX(created) AS last_created,
+	MAX(CASE WHEN low = 1 THEN price END) AS low,
+	MAX(CASE WHEN low = 1 THEN created END) AS low_first_seen,
+	MAX(CASE WHEN low = 1 THEN updated END) AS low_last_seen,
+	MAX(CASE WHEN high = 1 THEN price END) AS high,
+	MAX(CASE WHEN high = 1 THEN created END) AS high_first_seen,
+	MAX(CASE WHEN high = 1 THEN updated END) AS high_last_seen,
+	MAX(c.changes) AS changes
+FROM p JOIN c USING (product)
+GROUP BY product`
+
+// Days of the longest statistics window, see series.StatsAt
+const statsDays = 90
+
+type statsRow struct {
+	Product       string         `db:"product"`
+	Current       float64        `db:"current"`
+	CurrentSince  types.DateTime `db:"current_since"`
+	PreviousSeen  types.DateTime `db:"previous_seen"`
+	LastCreated   types.DateTime `db:"last_created"`
+	Low           float64        `db:"low"`
+	LowFirstSeen  types.DateTime `db:"low_first_seen"`
+	LowLastSeen   types.DateTime `db:"low_last_seen"`
+	High          float64        `db:"high"`
+	HighFirstSeen types.DateTime `db:"high_first_seen"`
+	HighLastSeen  types.DateTime `db:"high_last_seen"`
+	Changes       int            `db:"changes"`
+}
+
+// Compute price statistics of the given products, or of every product when no id is given
+func (s *Server) ProductStats(productIds ...string) ([]model.ProductStats, error) {
+	now := time.Now()
+	params := dbx.Params{}
+	filter := ""
+	if len(productIds) > 0 {
+		var placeholders []string
+		for i, id := range productIds {
+			key := fmt.Sprintf("product%d", i)
+			params[key] = id
+			placeholders = append(placeholders, "{:"+key+"}")
+		}
+		filter = "WHERE product IN (" + strings.Join(placeholders, ", ") + ")"
+	}
+
+	var rows []statsRow
+	query := fmt.Sprintf(statsQuery, filter)
+	if err := s.App.DB().NewQuery(query).Bind(params).All(&rows); err != nil {
+		return nil, err
+	}
+
+	stats := make([]model.ProductStats, 0, len(rows))
+	for _, row := range rows {
+		lastChanged := row.CurrentSince
+		if row.Changes > 0 && row.CurrentSince.Before(row.LastCreated) {
+			// the current price record was revived, so it changed when the previous price was last seen
+			lastChanged = row.PreviousSeen
+		}
+		// the records of a revived price overlap, so the windows are computed from the changes of the price like in the alerts
+		from := now.AddDate(0, 0, -statsDays)
+		intervals, err := s.GetPriceIntervals(row.Product, from, now)
+		if err != nil {
+			return nil, err
+		}
+		windows := series.StatsAt(series.Points(intervals, from, now), now)
+		stats = append(stats, model.ProductStats{
+			ProductId: row.Product,
+			Current:   row.Current,
+			AllTimeLow: model.PriceExtreme{
+				Price:     row.Low,
+				FirstSeen: row.LowFirstSeen.Time(),
+				LastSeen:  row.LowLastSeen.Time(),
+			},
+			AllTimeHigh: model.PriceExtreme{
+				Price:     row.High,
+				FirstSeen: row.HighFirstSeen.Time(),
+				LastSeen:  row.HighLastSeen.Time(),
+			},
+			Last7Days:       windows.Last7Days,
+			Last30Days:      windows.Last30Days,
+			Last90Days:      windows.Last90Days,
+			Changes:         row.Changes,
+			LastChanged:     lastChanged.Time(),
+			SinceLastChange: int64(now.Sub(lastChanged.Time()).Seconds()),
+			At30DayLow:      windows.At30DayLow,
+			DiscountQuality: s.DiscountQuality(row.Product, now),
+		})
+	}
+	return stats, nil
+}
+
+// Settings of the discount analysis from the environment
+func DiscountRules() series.DiscountRules {
+	return series.DiscountRules{
+		BumpDays:   utils.GetEnvInt("DISCOUNT_BUMP_DAYS", 14),
+		MinPercent: float64(utils.GetEnvInt("DISCOUNT_MIN_PERCENT", 10)),
+		MedianDays: utils.GetEnvInt("DISCOUNT_MEDIAN_DAYS", 60),
+	}
+}
+
+// Rate the last price change of a product until the given time, empty when it was not a drop.
+// Only a change in the last DiscountRules().Days() days is rated, so only twice those days of history are loaded.
+func (s *Server) DiscountQuality(productId string, t time.Time) string {
+	rules := DiscountRules()
+	from := t.AddDate(0, 0, -2*rules.Days())
+	intervals, err := s.GetPriceIntervals(productId, from, t)
+	if err != nil {
+		s.logger.Error("Failed to get price history", "product", productId, "error", err)
+		return ""
+	}
+	points := series.Points(intervals, from, t)
+	if changed, ok := series.LastChange(points, t); !ok || changed.Before(t.AddDate(0, 0, -rules.Days())) {
+		return ""
+	}
+	return series.DiscountQuality(points, t, rules)
+}
@@ -0,0 +1,64 @@
+package db
+
+import (
+	"dilogger/internal/push"
+	"slices"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Create new notification template collection in database, adding the event types missing from older versions
+func (s *Server) NewNotificationTemplateCollection() {
+	collection, err := s.App.FindCollectionByNameOrId("notification_templates")
+	if err != nil {
+		if err := s.App.Save(NewCollection("notification_templates")); err != nil {
+			s.logger.Error(err.Error())
+		}
+		return
+	}
+	event, ok := collection.Fields.GetByName("event").(*core.SelectField)
+	if !ok || slices.Equal(event.Values, push.EventTypes) {
+		return
+	}
+	event.Values = push.EventTypes
+	if err := s.App.Save(collection); err != nil {
+		s.logger.Error(err.Error())
+	}
+}
+
+// Check the notification templates when they are saved, so a broken template never reaches a channel
+func (s *Server) MessageTemplateHook() {
+	s.App.OnRecordValidate("notification_templates").BindFunc(func(e *core.RecordEvent) error {
+		if err := messageTemplate(e.Record).Check(); err != nil {
+			return validation.Errors{"body": validation.NewError("validation_invalid_template", err.Error())}
+		}
+		return e.Next()
+	})
+}
+
+// The `MessageTemplate` function finds the template of a channel for an event type, or for every event of the channel.
+func (s *Server) MessageTemplate(channel string, eventType string) (push.MessageTemplate, bool) {
+	records, err := s.App.FindRecordsByFilter(
+		"notification_templates",
+		"channel = {:channel} && (event = {:event} || event = '')",
+		"-event", 1, 0,
+		dbx.Params{"channel": channel, "event": eventType},
+	)
+	if err != nil || len(records) == 0 {
+		return push.MessageTemplate{}, false
+	}
+	return messageTemplate(records[0]), true
+}
+
+// Create message template from its record
+func messageTemplate(record *core.Record) push.MessageTemplate {
+	return push.MessageTemplate{
+		Channel: record.GetString("channel"),
+		Event:   record.GetString("event"),
+		Title:   record.GetString("title"),
+		Body:    record.GetString("body"),
+		HTML:    record.GetString("html"),
+	}
+}
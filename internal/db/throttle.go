@@ -0,0 +1,351 @@
+package db
+
+import (
+	"dilogger/internal/alert"
+	"dilogger/internal/push"
+	"dilogger/internal/utils"
+	"maps"
+	"slices"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Statuses of the notification log
+const (
+	NotificationSent       = "sent"
+	NotificationSuppressed = "suppressed"
+	NotificationHeld       = "held"
+	NotificationReleased   = "released"
+	NotificationFailed     = "failed"
+)
+
+// Events sent once by their own checks, like a price protection sent again only when the price drops further
+// or a budget sent again only after the total went back above it. Throttle only holds them during the quiet hours of their users.
+var onceEvents = []string{push.PriceProtection, push.Budget}
+
+// Statuses which can be stored in the notification log
+var NotificationStatuses = []string{NotificationSent, NotificationSuppressed, NotificationHeld, NotificationReleased, NotificationFailed}
+
+// Create new notification log collection in database, adding the statuses missing from older versions
+func (s *Server) NewNotificationLogCollection() {
+	if collection, err := s.App.FindCollectionByNameOrId("notification_log"); err == nil {
+		status, ok := collection.Fields.GetByName("status").(*core.SelectField)
+		if !ok || slices.Equal(status.Values, NotificationStatuses) {
+			return
+		}
+		status.Values = NotificationStatuses
+		if err := s.App.Save(collection); err != nil {
+			s.logger.Error(err.Error())
+		}
+		return
+	}
+	if err := s.App.Save(NewCollection("notification_log", NotificationStatuses)); err != nil {
+		s.logger.Error(err.Error())
+	}
+}
+
+// Validate the timezone, quiet hours and digest time of users when they are saved
+func (s *Server) UserSettingsHook() {
+	s.App.OnRecordValidate("users").BindFunc(func(e *core.RecordEvent) error {
+		if _, err := alert.ParseQuietHours("", e.Record.GetString("timezone")); err != nil {
+			return validation.Errors{"timezone": validation.NewError("validation_invalid_timezone", err.Error())}
+		}
+		if _, err := alert.ParseQuietHours(e.Record.GetString("quiet_hours"), e.Record.GetString("timezone")); err != nil {
+			return validation.Errors{"quiet_hours": validation.NewError("validation_invalid_quiet_hours", err.Error())}
+		}
+		if _, err := s.DigestSchedule(e.Record); err != nil {
+			return validation.Errors{"digest_time": validation.NewError("validation_invalid_digest_time", err.Error())}
+		}
+		return e.Next()
+	})
+}
+
+// Get the quiet hours of a user, nil when the user has none
+func (s *Server) QuietHours(userId string) *alert.QuietHours {
+	record, err := s.App.FindRecordById("users", userId)
+	if err != nil {
+		return nil
+	}
+	return s.userQuietHours(record)
+}
+
+// Get the quiet hours of a user record, nil when the user has none
+func (s *Server) userQuietHours(record *core.Record) *alert.QuietHours {
+	quiet, err := alert.ParseQuietHours(record.GetString("quiet_hours"), record.GetString("timezone"))
+	if err != nil {
+		s.logger.Error(err.Error(), "user", record.Id)
+	}
+	return quiet
+}
+
+// The `Throttle` function drops the events notified recently and holds the events of the users in their quiet hours,
+// whether they are targeted by their alert rules or receive the events reaching every subscriber of a user channel.
+// It returns the event with the users left to notify, ok is false when nobody is left. Dropped and held events are logged.
+//
+//   - a product notified in the last NOTIFY_COOLDOWN_MINUTES is in its cooldown
+//   - an event with the same type and prices as one notified to the same user, or to every subscriber, in the last NOTIFY_DEDUP_MINUTES is a duplicate
+//   - a user notified about the product in the last `alert_cooldown` minutes of the user is in its cooldown
+//
+// The cooldowns and the duplicates do not apply to the events sent once, see onceEvents.
+func (s *Server) Throttle(event push.Event) (push.Event, bool) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	once := slices.Contains(onceEvents, event.Type)
+	if minutes := utils.GetEnvInt("NOTIFY_COOLDOWN_MINUTES", 0); !once && minutes > 0 && s.notifiedSince(event, minutes) {
+		s.logNotification(event, "", NotificationSuppressed, "product_cooldown")
+		return event, false
+	}
+	dedupMinutes := utils.GetEnvInt("NOTIFY_DEDUP_MINUTES", 360)
+	if once {
+		dedupMinutes = 0
+	}
+	duplicate := func(user string) bool {
+		return dedupMinutes > 0 && s.notifiedSince(event, dedupMinutes,
+			dbx.HashExp{"event": event.Type, "old_price": event.OldPrice, "new_price": event.NewPrice, "user": user},
+		)
+	}
+	targets := map[string][]string{}
+	// held lists the backends on which each user in quiet hours was held
+	held := map[string]map[string][]string{}
+	if reachesAll(event) {
+		if duplicate("") {
+			s.logNotification(event, "", NotificationSuppressed, "duplicate")
+		} else {
+			targets, held = s.awakeSubscribers(event)
+			if event.Targets == nil && len(held) == 0 {
+				return event, true
+			}
+		}
+	}
+	for _, user := range targetedUsers(event) {
+		userEvent := event
+		userEvent.Targets = userTargets(event.Targets, user)
+		record, err := s.App.FindRecordById("users", user)
+		if err != nil {
+			s.logger.Error(err.Error(), "user", user)
+			continue
+		}
+		if duplicate(user) {
+			s.logNotification(userEvent, user, NotificationSuppressed, "duplicate")
+			continue
+		}
+		if minutes := record.GetInt("alert_cooldown"); !once && minutes > 0 && s.notifiedSince(event, minutes, dbx.HashExp{"user": user}) {
+			s.logNotification(userEvent, user, NotificationSuppressed, "user_cooldown")
+			continue
+		}
+		if s.QuietHours(user).Contains(event.Time) {
+			maps.Copy(userEvent.Targets, held[user])
+			delete(held, user)
+			s.logNotification(userEvent, user, NotificationHeld, "quiet_hours")
+			continue
+		}
+		for backend, users := range userEvent.Targets {
+			targets[backend] = append(targets[backend], users...)
+		}
+	}
+	for _, user := range slices.Sorted(maps.Keys(held)) {
+		userEvent := event
+		userEvent.Targets = held[user]
+		s.logNotification(userEvent, user, NotificationHeld, "quiet_hours")
+	}
+	event.Targets = targets
+	return event, len(targets) > 0
+}
+
+// The `awakeSubscribers` function returns the targets of the backends on which the event reaches every subscriber.
+// On the user channels, the subscribers in their quiet hours are held: the event is then sent to the other subscribers by user,
+// or to all of them when nobody is held.
+func (s *Server) awakeSubscribers(event push.Event) (targets map[string][]string, held map[string]map[string][]string) {
+	targets = map[string][]string{}
+	held = map[string]map[string][]string{}
+	for _, backend := range AlertChannels {
+		if event.Targets != nil && !slices.Contains(event.Targets[backend], push.AllSubscribers) {
+			continue
+		}
+		targets[backend] = []string{push.AllSubscribers}
+		if !slices.Contains(UserChannels, backend) {
+			continue
+		}
+		users, err := s.channelSubscribers(backend)
+		if err != nil {
+			s.logger.Error(err.Error())
+			continue
+		}
+		var awake []string
+		quiet := false
+		for _, record := range users {
+			user := record.Id
+			if !s.userQuietHours(record).Contains(event.Time) {
+				awake = append(awake, user)
+				continue
+			}
+			quiet = true
+			if held[user] == nil {
+				held[user] = map[string][]string{}
+			}
+			held[user][backend] = []string{user}
+		}
+		switch {
+		case !quiet:
+		case len(awake) == 0:
+			delete(targets, backend)
+		default:
+			targets[backend] = awake
+		}
+	}
+	return targets, held
+}
+
+// Get the users receiving the events which reach every subscriber of a user channel, those without an alert rule on the channel
+func (s *Server) channelSubscribers(channel string) ([]*core.Record, error) {
+	var exp dbx.Expression
+	switch channel {
+	case "email":
+		exp = dbx.HashExp{"email_alerts": true}
+	case "webpush":
+		exp = dbx.NewExp("EXISTS (SELECT 1 FROM push_subscriptions p WHERE p.user = users.id)")
+	case "onesignal":
+		exp = dbx.NewExp("EXISTS (SELECT 1 FROM onesignal_subscriptions o WHERE o.user = users.id)")
+	default:
+		return nil, nil
+	}
+	return s.App.FindAllRecords("users", exp, withoutAlertRule("users.id", channel))
+}
+
+// Log an event which was sent, once for every targeted user and once without user when it reached every subscriber of a backend
+func (s *Server) LogSentNotification(event push.Event) {
+	if reachesAll(event) {
+		s.logNotification(event, "", NotificationSent, "")
+	}
+	for _, user := range targetedUsers(event) {
+		s.logNotification(event, user, NotificationSent, "")
+	}
+}
+
+// The `ReleaseHeldNotifications` function sends the events held for the users whose quiet hours are over,
+// merged into a summary delivered in a single message by the batch backends.
+func (s *Server) ReleaseHeldNotifications() {
+	records, err := s.App.FindRecordsByFilter("notification_log", "status = {:status}", "created", 0, 0, dbx.Params{"status": NotificationHeld})
+	if err != nil {
+		s.logger.Error(err.Error())
+		return
+	}
+	var users []string
+	held := map[string][]*core.Record{}
+	for _, record := range records {
+		user := record.GetString("user")
+		if _, ok := held[user]; !ok {
+			users = append(users, user)
+		}
+		held[user] = append(held[user], record)
+	}
+	now := time.Now()
+	for _, user := range users {
+		if s.QuietHours(user).Contains(now) {
+			continue
+		}
+		var events []push.Event
+		for _, record := range held[user] {
+			var event push.Event
+			if err := record.UnmarshalJSONField("payload", &event); err != nil {
+				s.logger.Error(err.Error())
+				continue
+			}
+			events = append(events, event)
+		}
+		summary := push.Summarize(events)
+		s.Notification.Hold()
+		for _, event := range summary {
+			s.Notification.Send(event)
+		}
+		s.Notification.Flush()
+		for _, record := range held[user] {
+			record.Set("status", NotificationReleased)
+			if err := s.App.Save(record); err != nil {
+				s.logger.Error(err.Error())
+			}
+		}
+		s.logger.Info("released held notifications", "user", user, "held", len(events), "sent", len(summary))
+	}
+}
+
+// Check whether the product of the event was notified in the last minutes, filtered by the extra expressions
+func (s *Server) notifiedSince(event push.Event, minutes int, exps ...dbx.Expression) bool {
+	exps = append(exps,
+		dbx.HashExp{"product": event.Product.Id},
+		dbx.In("status", NotificationSent, NotificationReleased),
+		dbx.NewExp("created >= {:since}", dbx.Params{"since": FormatDate(event.Time.Add(-time.Duration(minutes) * time.Minute))}),
+	)
+	count, err := s.App.CountRecords("notification_log", exps...)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return false
+	}
+	return count > 0
+}
+
+// Save an event into the notification log, suppressed and held events are logged by the app logger too
+func (s *Server) logNotification(event push.Event, user string, status string, reason string) {
+	if status != NotificationSent {
+		s.logger.Info("notification "+status, "event", event.Type, "product", event.Product.Id, "user", user, "reason", reason)
+	}
+	collection, err := s.App.FindCachedCollectionByNameOrId("notification_log")
+	if err != nil {
+		s.logger.Error(err.Error())
+		return
+	}
+	record := core.NewRecord(collection)
+	record.Set("event", event.Type)
+	record.Set("product", event.Product.Id)
+	record.Set("user", user)
+	record.Set("old_price", event.OldPrice)
+	record.Set("new_price", event.NewPrice)
+	record.Set("status", status)
+	record.Set("reason", reason)
+	record.Set("payload", event)
+	if err := s.App.Save(record); err != nil {
+		s.logger.Error(err.Error())
+	}
+}
+
+// Check whether the event reaches every subscriber of at least one backend
+func reachesAll(event push.Event) bool {
+	if event.Targets == nil {
+		return true
+	}
+	for _, users := range event.Targets {
+		if slices.Contains(users, push.AllSubscribers) {
+			return true
+		}
+	}
+	return false
+}
+
+// Users targeted on any backend of the event, in a stable order
+func targetedUsers(event push.Event) []string {
+	var users []string
+	for _, backendUsers := range event.Targets {
+		for _, user := range backendUsers {
+			if user != push.AllSubscribers && !slices.Contains(users, user) {
+				users = append(users, user)
+			}
+		}
+	}
+	slices.Sort(users)
+	return users
+}
+
+// Backends of the targets which reach the user, targeting only that user
+func userTargets(targets map[string][]string, user string) map[string][]string {
+	filtered := map[string][]string{}
+	for backend, users := range targets {
+		if slices.Contains(users, user) {
+			filtered[backend] = []string{user}
+		}
+	}
+	return filtered
+}
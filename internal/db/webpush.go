@@ -0,0 +1,152 @@
+package db
+
+import (
+	"dilogger/internal/push"
+	"dilogger/internal/utils"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/list"
+)
+
+// File in the data directory holding the generated VAPID keys
+const vapidKeysFile = "vapid_keys.json"
+
+// VAPID key pair identifying the server to the push services
+type VAPIDKeys struct {
+	PublicKey  string `json:"public_key"`
+	PrivateKey string `json:"private_key"`
+}
+
+// Create new push subscription collection in database
+func (s *Server) NewPushSubscriptionCollection() {
+	if _, err := s.App.FindCollectionByNameOrId("push_subscriptions"); err == nil {
+		return
+	}
+	users, err := s.App.FindCollectionByNameOrId("users")
+	if err != nil {
+		s.logger.Error(err.Error())
+		return
+	}
+	if err := s.App.Save(NewCollection("push_subscriptions", users.Id)); err != nil {
+		s.logger.Error(err.Error())
+	}
+}
+
+// The `VAPIDKeys` function reads the keys from VAPID_PUBLIC_KEY and VAPID_PRIVATE_KEY or from the data directory,
+// generating and storing a new pair when there is none.
+func (s *Server) VAPIDKeys() (VAPIDKeys, error) {
+	keys := VAPIDKeys{
+		PublicKey:  utils.GetEnv("VAPID_PUBLIC_KEY", ""),
+		PrivateKey: utils.GetEnv("VAPID_PRIVATE_KEY", ""),
+	}
+	if keys.PublicKey != "" && keys.PrivateKey != "" {
+		return keys, nil
+	}
+	path := filepath.Join(s.App.DataDir(), vapidKeysFile)
+	data, err := os.ReadFile(path)
+	if err == nil {
+		err = json.Unmarshal(data, &keys)
+		return keys, err
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return keys, err
+	}
+	keys.PublicKey, keys.PrivateKey, err = push.GenerateVAPIDKeys()
+	if err != nil {
+		return keys, err
+	}
+	if data, err = json.Marshal(keys); err != nil {
+		return keys, err
+	}
+	return keys, os.WriteFile(path, data, 0600)
+}
+
+// Add the Web Push backend to the notifications once the data directory is available
+func (s *Server) InitWebPush() {
+	if slices.Contains(s.Notification.Backends(), "webpush") {
+		return
+	}
+	keys, err := s.VAPIDKeys()
+	if err != nil {
+		s.logger.Error("webpush notifications disabled", "reason", err.Error())
+		return
+	}
+	webPush, err := push.NewWebPush(keys.PublicKey, keys.PrivateKey, s.PushSubscriptions, s.DeletePushSubscription)
+	if err != nil {
+		s.logger.Error("webpush notifications disabled", "reason", err.Error())
+		return
+	}
+	s.Notification.Add(webPush)
+}
+
+// ErrSubscriptionTaken is returned when a user saves a subscription which belongs to another user
+var ErrSubscriptionTaken = errors.New("the subscription belongs to another user")
+
+// Save a browser push subscription of a user, the endpoint must be on a push service, see push.CheckEndpoint.
+// An existing subscription of the endpoint is updated when it belongs to the user, ErrSubscriptionTaken is returned otherwise.
+func (s *Server) SavePushSubscription(subscription push.Subscription, userAgent string) (*core.Record, error) {
+	if err := push.CheckEndpoint(subscription.Endpoint); err != nil {
+		return nil, err
+	}
+	record, err := s.App.FindFirstRecordByData("push_subscriptions", "endpoint", subscription.Endpoint)
+	if err == nil && record.GetString("user") != subscription.User {
+		return nil, ErrSubscriptionTaken
+	}
+	if err != nil {
+		collection, err := s.App.FindCachedCollectionByNameOrId("push_subscriptions")
+		if err != nil {
+			return nil, err
+		}
+		record = core.NewRecord(collection)
+		record.Set("endpoint", subscription.Endpoint)
+	}
+	record.Set("user", subscription.User)
+	record.Set("p256dh", subscription.P256dh)
+	record.Set("auth", subscription.Auth)
+	record.Set("user_agent", userAgent)
+	return record, s.App.Save(record)
+}
+
+// Get the browser push subscriptions receiving an event, those of the users targeted by the alert rules
+// and, when the event reaches every subscriber, those of the users without a webpush alert rule
+func (s *Server) PushSubscriptions(event push.Event) ([]push.Subscription, error) {
+	users, all := event.Users("webpush")
+	exps := []dbx.Expression{dbx.In("user", list.ToInterfaceSlice(users)...)}
+	if all {
+		exps = append(exps, withoutAlertRule("push_subscriptions.user", "webpush"))
+	}
+	records, err := s.App.FindAllRecords("push_subscriptions", dbx.Or(exps...))
+	if err != nil {
+		return nil, err
+	}
+	var subscriptions []push.Subscription
+	for _, record := range records {
+		subscriptions = append(subscriptions, push.Subscription{
+			Id:       record.Id,
+			User:     record.GetString("user"),
+			Endpoint: record.GetString("endpoint"),
+			P256dh:   record.GetString("p256dh"),
+			Auth:     record.GetString("auth"),
+		})
+	}
+	return subscriptions, nil
+}
+
+// Delete a push subscription which is no longer valid
+func (s *Server) DeletePushSubscription(subscription push.Subscription) {
+	record, err := s.App.FindRecordById("push_subscriptions", subscription.Id)
+	if err != nil {
+		return
+	}
+	if err := s.App.Delete(record); err != nil {
+		s.logger.Error(err.Error())
+		return
+	}
+	s.logger.Info("removed expired push subscription", "user", subscription.User)
+}
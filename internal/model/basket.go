@@ -0,0 +1,26 @@
+package model
+
+import (
+	"time"
+)
+
+// Basket total model, the value of the products listed on a wishlist URL multiplied by their quantities
+type BasketTotal struct {
+	Id           string    `json:"id,omitempty"`
+	Url          string    `json:"url"`
+	Total        float64   `json:"total"`
+	InStockTotal float64   `json:"in_stock_total"`
+	Items        int       `json:"items"`
+	OutOfStock   int       `json:"out_of_stock"`
+	Updated      time.Time `json:"updated"`
+}
+
+// Basket history model, the total of a wishlist URL over time
+type BasketHistory struct {
+	Url         string       `json:"url"`
+	InStockOnly bool         `json:"in_stock_only"`
+	Bucket      string       `json:"bucket"`
+	From        time.Time    `json:"from"`
+	To          time.Time    `json:"to"`
+	Points      []PricePoint `json:"points"`
+}
@@ -6,10 +6,12 @@ import (
 
 // Product model
 type Product struct {
-	Id        string    `form:"id" json:"id"`
-	Name      string    `form:"name" json:"name"`
-	Stock     int32     `form:"stock" json:"stock"`
-	Price     float64   `form:"price" json:"price"`
+	Id    string  `form:"id" json:"id"`
+	Name  string  `form:"name" json:"name"`
+	Stock int32   `form:"stock" json:"stock"`
+	Price float64 `form:"price" json:"price"`
+	// Url of the wishlist the product was scraped from, only set during a scrape run
+	Url       string    `form:"url" json:"url,omitempty"`
 	CreatedAt time.Time `form:"created" json:"created"`
 	UpdatedAt time.Time `form:"updated" json:"updated"`
 }
@@ -0,0 +1,66 @@
+package model
+
+import (
+	"time"
+)
+
+// Price interval model, the price observed from Start until End
+type PriceInterval struct {
+	Price float64   `json:"price"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// Price point model used in history series
+type PricePoint struct {
+	Time  time.Time `json:"time"`
+	Price float64   `json:"price"`
+	Open  float64   `json:"open,omitempty"`
+	High  float64   `json:"high,omitempty"`
+	Low   float64   `json:"low,omitempty"`
+}
+
+// Stock point model, the stock of a product at the given time
+type StockPoint struct {
+	Time  time.Time `json:"time"`
+	Stock int       `json:"stock"`
+}
+
+// Price history model
+type PriceHistory struct {
+	Product Product      `json:"product"`
+	Bucket  string       `json:"bucket"`
+	From    time.Time    `json:"from"`
+	To      time.Time    `json:"to"`
+	Points  []PricePoint `json:"points"`
+	Stock   []StockPoint `json:"stock"`
+}
+
+// Price extreme model, a price level with the first and last time it was seen
+type PriceExtreme struct {
+	Price     float64   `json:"price"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// Window statistics model for the last N days
+type WindowStats struct {
+	Min float64 `json:"min"`
+	Avg float64 `json:"avg"`
+}
+
+// Product statistics model
+type ProductStats struct {
+	ProductId       string       `json:"product"`
+	Current         float64      `json:"current"`
+	AllTimeLow      PriceExtreme `json:"all_time_low"`
+	AllTimeHigh     PriceExtreme `json:"all_time_high"`
+	Last7Days       WindowStats  `json:"last_7_days"`
+	Last30Days      WindowStats  `json:"last_30_days"`
+	Last90Days      WindowStats  `json:"last_90_days"`
+	Changes         int          `json:"changes"`
+	LastChanged     time.Time    `json:"last_changed"`
+	SinceLastChange int64        `json:"since_last_change"` // seconds
+	At30DayLow      bool         `json:"at_30_day_low"`
+	DiscountQuality string       `json:"discount_quality,omitempty"` // genuine, inflated or above_median when the last change was a drop
+}
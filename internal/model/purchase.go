@@ -0,0 +1,29 @@
+package model
+
+import (
+	"time"
+)
+
+// Purchase savings model, the price paid compared with the 90-day average price of the product
+type PurchaseSavings struct {
+	PurchaseId string    `json:"purchase"`
+	ProductId  string    `json:"product"`
+	Name       string    `json:"name"`
+	Paid       float64   `json:"paid"`
+	Purchased  time.Time `json:"purchased"`
+	Current    float64   `json:"current"`
+	// Average is zero when no price was seen in the 90 days before the purchase, the purchase is then not compared
+	Average      float64 `json:"average_90d"`
+	Saved        float64 `json:"saved"`
+	SavedPercent float64 `json:"saved_percent"`
+}
+
+// Savings report model, the totals only include the compared purchases
+type SavingsReport struct {
+	Purchases    []PurchaseSavings `json:"purchases"`
+	Compared     int               `json:"compared"`
+	Paid         float64           `json:"paid"`
+	Average      float64           `json:"average_90d"`
+	Saved        float64           `json:"saved"`
+	SavedPercent float64           `json:"saved_percent"`
+}
@@ -2,11 +2,11 @@ package parser
 
 import (
 	"dilogger/internal/model"
-	"log"
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"golang.org/x/net/html"
@@ -14,20 +14,19 @@ import (
 )
 
 // The Parse function reads HTML content from a given URL, parses it to extract table rows, and sends each row to a channel for further processing.
-func Parse(ch chan model.Product, wg *sync.WaitGroup, url string) {
-	defer wg.Done()
+// An error is returned when the page cannot be fetched or has no product table.
+func Parse(ch chan model.Product, url string) error {
 	resp, err := http.Get(url)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	body := resp.Body
-	if err != nil {
-		log.Fatal(err)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
 	}
-	doc, err := html.Parse(body)
-	body.Close()
+	doc, err := html.Parse(resp.Body)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	var tbody *html.Node
 	for n := range doc.Descendants() {
@@ -35,11 +34,17 @@ func Parse(ch chan model.Product, wg *sync.WaitGroup, url string) {
 			tbody = n
 		}
 	}
+	if tbody == nil {
+		return errors.New("no product table")
+	}
 	for n := range tbody.ChildNodes() {
 		if n.Data == "tr" {
-			ch <- ParseRow(n)
+			product := ParseRow(n)
+			product.Url = url
+			ch <- product
 		}
 	}
+	return nil
 }
 
 // The ParseRow function extracts product information from an HTML row and returns a model.Product struct.
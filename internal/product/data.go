@@ -4,18 +4,31 @@ import (
 	"dilogger/internal/db"
 	"dilogger/internal/model"
 	"dilogger/internal/parser"
+	"dilogger/internal/utils"
+	"path/filepath"
 	"sync"
 )
 
 // The GetProducts function concurrently fetches and parses product data from multiple URLs using goroutines and channels.
-func GetProducts(urls []string) (products []model.Product) {
+// The URLs which could not be scraped are returned in failed with their error.
+func GetProducts(urls []string) (products []model.Product, failed map[string]error) {
 
 	var wg sync.WaitGroup
+	var mu sync.Mutex
 	ch := make(chan model.Product)
+	failed = map[string]error{}
+	done := make(chan struct{})
 
 	for _, url := range urls {
 		wg.Add(1)
-		go parser.Parse(ch, &wg, url)
+		go func() {
+			defer wg.Done()
+			if err := parser.Parse(ch, url); err != nil {
+				mu.Lock()
+				failed[url] = err
+				mu.Unlock()
+			}
+		}()
 	}
 
 	// This block is creating an anonymous goroutine that reads from the channel `ch` and appends the received `model.Product` objects to the `products` slice.
@@ -23,16 +36,70 @@ func GetProducts(urls []string) (products []model.Product) {
 		for product := range ch {
 			products = append(products, product)
 		}
+		close(done)
 	}()
 
 	wg.Wait()
 	close(ch)
+	<-done
 
 	return
 }
 
-// Reload data from urls and add to database
-func ReloadData(server *db.Server) {
+// Reload data from urls and add to database, the notifications of the run are batched and its price drops checked for a sale.
+// It returns false without reloading while another reload is in progress.
+func ReloadData(server *db.Server) bool {
+	run, ok := server.StartRun()
+	if !ok {
+		server.Logger().Warn("reload skipped, another reload is in progress")
+		return false
+	}
+	server.Notification.Hold()
+	defer server.Notification.Flush()
+	defer server.FinishRun(run)
 	urls := server.GetURLs()
-	server.AddToCollection(GetProducts(urls))
+	products, failed := GetProducts(urls)
+	for url, err := range failed {
+		server.Logger().Error("scrape failed", "url", url, "error", err.Error())
+		server.SkipRunListings(url)
+	}
+	server.AddToCollection(products)
+	return true
+}
+
+// Apply the RETENTION_RULES to the collections, only reporting what would be deleted on a dry run
+func ApplyRetention(server *db.Server, dryRun bool) ([]db.RetentionReport, error) {
+	rules, err := db.ParseRetentionRules(utils.GetEnv("RETENTION_RULES", ""))
+	if err != nil {
+		return nil, err
+	}
+	reports, err := server.ApplyRetention(rules, dryRun)
+	for _, report := range reports {
+		server.Logger().Info(
+			"retention",
+			"collection", report.Collection,
+			"cutoff", report.Cutoff,
+			"matched", report.Matched,
+			"dryRun", report.DryRun,
+		)
+	}
+	return reports, err
+}
+
+// Create a backup in BACKUP_DIR and keep only BACKUP_KEEP_DAILY daily and BACKUP_KEEP_WEEKLY weekly backups
+func Backup(server *db.Server) (string, error) {
+	dir := utils.GetEnv("BACKUP_DIR", filepath.Join(server.App.DataDir(), "backups"))
+	path, err := server.CreateBackup(dir)
+	if err != nil {
+		return "", err
+	}
+	removed, err := server.RotateBackups(
+		dir,
+		utils.GetEnvInt("BACKUP_KEEP_DAILY", 7),
+		utils.GetEnvInt("BACKUP_KEEP_WEEKLY", 4),
+	)
+	for _, name := range removed {
+		server.Logger().Info("removed old backup", "name", name)
+	}
+	return path, err
 }
@@ -0,0 +1,273 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Events posted to a chat channel when none are configured
+var defaultChatEvents = []string{PriceChange, BackInStock}
+
+// Incoming webhook of a chat channel and the event types posted to it
+type chatChannel struct {
+	url    string
+	events []string
+}
+
+// The `parseChannels` function parses comma separated channels written as "url" or "url|price_change+back_in_stock".
+func parseChannels(value string) ([]chatChannel, error) {
+	var channels []chatChannel
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		url, events, found := strings.Cut(entry, "|")
+		channel := chatChannel{url: strings.TrimSpace(url), events: defaultChatEvents}
+		if found {
+			channel.events = nil
+			for _, event := range strings.Split(events, "+") {
+				event = strings.TrimSpace(event)
+				if !slices.Contains([]string{PriceChange, BackInStock, OutOfStock, Listed, Delisted}, event) {
+					return nil, fmt.Errorf("invalid event '%s' of channel %s", event, channel.url)
+				}
+				channel.events = append(channel.events, event)
+			}
+		}
+		if err := checkURL("channel", channel.url); err != nil {
+			return nil, err
+		}
+		channels = append(channels, channel)
+	}
+	return channels, nil
+}
+
+// Events of the batch accepted by the channel, a sale is accepted with the price changes since it is made of drops
+func (c chatChannel) filter(events []Event) []Event {
+	var accepted []Event
+	for _, event := range events {
+		eventType := event.Type
+		if eventType == Sale {
+			eventType = PriceChange
+		}
+		if slices.Contains(c.events, eventType) {
+			accepted = append(accepted, event)
+		}
+	}
+	return accepted
+}
+
+// Post a JSON payload with extra headers to a URL, the id of the response is recorded when there is one
+func postJSON(ctx context.Context, client *http.Client, url string, headers map[string]string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	var out struct {
+		Id any `json:"id"`
+	}
+	if json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&out) == nil && out.Id != nil {
+		SetResponseID(ctx, fmt.Sprint(out.Id))
+	}
+	return nil
+}
+
+// Post the events of every channel split in chunks of at most size events.
+// A channel stops at its first failed chunk, the events from that chunk on are left pending so that a retry does not post the others again.
+func postChannels(ctx context.Context, channels []chatChannel, events []Event, size int, post func(ctx context.Context, url string, events []Event) error) error {
+	var errs []error
+	for _, channel := range channels {
+		if !includes(ctx, channel.url) {
+			continue
+		}
+		accepted := channel.filter(events)
+		for start := 0; start < len(accepted); start += size {
+			chunk := accepted[start:min(start+size, len(accepted))]
+			if err := post(ctx, channel.url, chunk); err != nil {
+				recipientErr := &RecipientError{Recipient: channel.url, Err: err}
+				if start > 0 {
+					recipientErr.Pending = accepted[start:]
+				}
+				errs = append(errs, recipientErr)
+				break
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Discord posts events as embeds to Discord incoming webhooks
+type Discord struct {
+	appName  string
+	channels []chatChannel
+	client   *http.Client
+}
+
+// Create new Discord notifier from the comma separated DISCORD_WEBHOOKS channels
+func NewDiscord(appName string, webhooks string) (*Discord, error) {
+	channels, err := parseChannels(webhooks)
+	if err != nil {
+		return nil, err
+	}
+	if len(channels) == 0 {
+		return nil, fmt.Errorf("%w: DISCORD_WEBHOOKS is not set", ErrNotConfigured)
+	}
+	return &Discord{appName, channels, &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+// Name of the Discord backend
+func (d *Discord) Name() string {
+	return "discord"
+}
+
+// Post a single event
+func (d *Discord) Notify(ctx context.Context, event Event) error {
+	return d.NotifyBatch(ctx, []Event{event})
+}
+
+// The `NotifyBatch` function posts the events with one embed each, a Discord message holds at most 10 embeds.
+func (d *Discord) NotifyBatch(ctx context.Context, events []Event) error {
+	return postChannels(ctx, d.channels, events, 10, func(ctx context.Context, url string, events []Event) error {
+		embeds := make([]map[string]any, len(events))
+		for i, event := range events {
+			embed, err := d.embed(event)
+			if err != nil {
+				return err
+			}
+			embeds[i] = embed
+		}
+		return postJSON(ctx, d.client, url, nil, map[string]any{
+			"username": d.appName,
+			"embeds":   embeds,
+		})
+	})
+}
+
+// Build the embed of an event
+func (d *Discord) embed(event Event) (map[string]any, error) {
+	message, err := RenderMessage(d.Name(), event, Recipient{})
+	if err != nil {
+		return nil, err
+	}
+	color := 0x808080
+	switch {
+	case event.IsDrop(), event.Type == PriceProtection:
+		color = 0x2ecc71
+	case event.Type == PriceChange:
+		color = 0xe74c3c
+	case event.Type == BackInStock:
+		color = 0x3498db
+	}
+	return map[string]any{
+		"title":       truncate(message.Title, 256),
+		"url":         event.Link(),
+		"description": truncate(message.Body, 4096),
+		"color":       color,
+		"footer":      map[string]string{"text": event.Title()},
+		"timestamp":   event.Time.Format(time.RFC3339),
+	}, nil
+}
+
+// Slack posts events as blocks to Slack incoming webhooks
+type Slack struct {
+	appName  string
+	channels []chatChannel
+	client   *http.Client
+}
+
+// Create new Slack notifier from the comma separated SLACK_WEBHOOKS channels
+func NewSlack(appName string, webhooks string) (*Slack, error) {
+	channels, err := parseChannels(webhooks)
+	if err != nil {
+		return nil, err
+	}
+	if len(channels) == 0 {
+		return nil, fmt.Errorf("%w: SLACK_WEBHOOKS is not set", ErrNotConfigured)
+	}
+	return &Slack{appName, channels, &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+// Name of the Slack backend
+func (s *Slack) Name() string {
+	return "slack"
+}
+
+// Post a single event
+func (s *Slack) Notify(ctx context.Context, event Event) error {
+	return s.NotifyBatch(ctx, []Event{event})
+}
+
+// The `NotifyBatch` function posts the events with one section block each below a header, a Slack message holds at most 50 blocks.
+func (s *Slack) NotifyBatch(ctx context.Context, events []Event) error {
+	return postChannels(ctx, s.channels, events, 40, func(ctx context.Context, url string, events []Event) error {
+		summary := fmt.Sprintf("%s: %d product updates", s.appName, len(events))
+		if len(events) == 1 {
+			summary = events[0].Title()
+			if events[0].Product.Name != "" {
+				summary += ": " + events[0].Product.Name
+			}
+		}
+		blocks := []map[string]any{{
+			"type": "header",
+			"text": map[string]string{"type": "plain_text", "text": truncate(summary, 150)},
+		}}
+		for _, event := range events {
+			text, err := s.section(event)
+			if err != nil {
+				return err
+			}
+			blocks = append(blocks, map[string]any{
+				"type": "section",
+				"text": map[string]string{"type": "mrkdwn", "text": truncate(text, 3000)},
+			})
+		}
+		return postJSON(ctx, s.client, url, nil, map[string]any{
+			"text":   summary,
+			"blocks": blocks,
+		})
+	})
+}
+
+// Build the mrkdwn text of an event, its title links to the price chart above its body
+func (s *Slack) section(event Event) (string, error) {
+	message, err := RenderMessage(s.Name(), event, Recipient{})
+	if err != nil {
+		return "", err
+	}
+	escape := strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	return fmt.Sprintf("*<%s|%s>*\n%s", event.Link(), escape.Replace(message.Title), message.Body), nil
+}
+
+// Cut a text to at most size runes
+func truncate(text string, size int) string {
+	runes := []rune(text)
+	if len(runes) <= size {
+		return text
+	}
+	return string(runes[:size-1]) + "…"
+}
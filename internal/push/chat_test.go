@@ -0,0 +1,64 @@
+package push
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestPostChannelsPending(t *testing.T) {
+	channels := []chatChannel{{url: "https://a.example", events: defaultChatEvents}, {url: "https://b.example", events: defaultChatEvents}}
+	var events []Event
+	for price := range 5 {
+		events = append(events, Event{Type: PriceChange, OldPrice: 100, NewPrice: float64(price)})
+	}
+	prices := func(events []Event) []float64 {
+		var prices []float64
+		for _, event := range events {
+			prices = append(prices, event.NewPrice)
+		}
+		return prices
+	}
+	posted := map[string][][]float64{}
+	// the second chunk of channel a fails once
+	fails := 1
+	post := func(ctx context.Context, url string, chunk []Event) error {
+		if url == "https://a.example" && chunk[0].NewPrice == 2 && fails > 0 {
+			fails--
+			return errors.New("unexpected status 502 Bad Gateway")
+		}
+		posted[url] = append(posted[url], prices(chunk))
+		return nil
+	}
+
+	err := postChannels(context.Background(), channels, events, 2, post)
+	if failed, ok := FailedRecipients(err); !ok || !slices.Equal(failed, []string{"https://a.example"}) {
+		t.Fatalf("postChannels() error = %v, want a failure of channel a only", err)
+	}
+	pending := PendingEvents(err)["https://a.example"]
+	if got := prices(pending); !slices.Equal(got, []float64{2, 3, 4}) {
+		t.Errorf("pending events = %v, want the events from the failed chunk on", got)
+	}
+	if len(posted["https://b.example"]) != 3 {
+		t.Errorf("channel b received %v", posted["https://b.example"])
+	}
+
+	// the retry of channel a posts the pending events only
+	err = postChannels(withRecipient(context.Background(), "https://a.example"), channels, pending, 2, post)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]float64{{0, 1}, {2, 3}, {4}}
+	if got := posted["https://a.example"]; !slices.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("channel a received %v, want %v", got, want)
+	}
+
+	// a channel failing at its first chunk has nothing delivered, so the whole job is retried
+	fails = 1
+	events[0].NewPrice = 2
+	err = postChannels(context.Background(), channels[:1], events[:1], 2, post)
+	if pending := PendingEvents(err); len(pending) != 0 {
+		t.Errorf("pending events = %v, want none", pending)
+	}
+}
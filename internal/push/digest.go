@@ -0,0 +1,121 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"dilogger/internal/model"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// Periods of the digests
+const (
+	Daily  = "daily"
+	Weekly = "weekly"
+)
+
+// Changes of a product during the period of a digest
+type DigestItem struct {
+	Product  model.Product `json:"product"`
+	OldPrice float64       `json:"old_price"`
+	NewPrice float64       `json:"new_price"`
+	// Changes counts the price changes of the period, the prices may be back to the old price
+	Changes    int  `json:"changes"`
+	AllTimeLow bool `json:"all_time_low"`
+	OldStock   int  `json:"old_stock"`
+	NewStock   int  `json:"new_stock"`
+	Added      bool `json:"added"`
+	Removed    bool `json:"removed"`
+}
+
+// Change of the price in percent of the old price
+func (i DigestItem) ChangePercent() float64 {
+	return Event{OldPrice: i.OldPrice, NewPrice: i.NewPrice}.ChangePercent()
+}
+
+// Link to the product chart in the web UI
+func (i DigestItem) Link() string {
+	return Event{Product: i.Product}.Link()
+}
+
+// Digest of the changes of a period sent to a user
+type Digest struct {
+	User   Recipient    `json:"user"`
+	Period string       `json:"period"`
+	From   time.Time    `json:"from"`
+	To     time.Time    `json:"to"`
+	Items  []DigestItem `json:"items"`
+}
+
+// DigestNotifier is a backend able to deliver digests
+type DigestNotifier interface {
+	Notifier
+	// NotifyDigest delivers the digest of a user
+	NotifyDigest(ctx context.Context, digest Digest) error
+}
+
+// Check whether nothing changed during the period
+func (d Digest) Empty() bool {
+	return len(d.Items) == 0
+}
+
+// Items whose price went down during the period
+func (d Digest) Drops() []DigestItem {
+	return d.filter(func(i DigestItem) bool { return !i.Added && i.NewPrice < i.OldPrice })
+}
+
+// Items whose price went up during the period
+func (d Digest) Increases() []DigestItem {
+	return d.filter(func(i DigestItem) bool { return !i.Added && i.NewPrice > i.OldPrice })
+}
+
+// Items which reached a new all-time low during the period
+func (d Digest) Lows() []DigestItem {
+	return d.filter(func(i DigestItem) bool { return i.AllTimeLow })
+}
+
+// Items back in stock at the end of the period
+func (d Digest) Restocked() []DigestItem {
+	return d.filter(func(i DigestItem) bool { return i.OldStock <= 0 && i.NewStock > 0 })
+}
+
+// Items out of stock at the end of the period
+func (d Digest) SoldOut() []DigestItem {
+	return d.filter(func(i DigestItem) bool { return i.OldStock > 0 && i.NewStock <= 0 })
+}
+
+// Items added to the wishlists during the period
+func (d Digest) Added() []DigestItem {
+	return d.filter(func(i DigestItem) bool { return i.Added })
+}
+
+// Items no longer found on the wishlists during the period
+func (d Digest) Removed() []DigestItem {
+	return d.filter(func(i DigestItem) bool { return i.Removed })
+}
+
+func (d Digest) filter(keep func(DigestItem) bool) []DigestItem {
+	var items []DigestItem
+	for _, item := range d.Items {
+		if keep(item) {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Plain text templates of the digests shared by the chat and push backends
+var digestTemplate = texttemplate.Must(texttemplate.New("digest").Funcs(templateFuncs).ParseFS(templates, "templates/digest.txt"))
+
+// The `Render` function executes the plain text title and text templates of the digest.
+func (d Digest) Render() (title string, text string, err error) {
+	var titleBuf, textBuf bytes.Buffer
+	if err := digestTemplate.ExecuteTemplate(&titleBuf, "title", d); err != nil {
+		return "", "", err
+	}
+	if err := digestTemplate.ExecuteTemplate(&textBuf, "text", d); err != nil {
+		return "", "", err
+	}
+	return strings.TrimSpace(titleBuf.String()), strings.TrimSpace(textBuf.String()), nil
+}
@@ -0,0 +1,189 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"dilogger/internal/utils"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"net/mail"
+	texttemplate "text/template"
+
+	"github.com/pocketbase/pocketbase/tools/mailer"
+)
+
+//go:embed templates
+var templates embed.FS
+
+// Recipient of a notification
+type Recipient struct {
+	Id    string `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// Email sends price drop and stock events to the subscribed users through the app mailer
+type Email struct {
+	appName    string
+	mailer     func() mailer.Mailer
+	sender     func() mail.Address
+	recipients func(Event) ([]Recipient, error)
+	text       *texttemplate.Template
+	html       *htmltemplate.Template
+}
+
+// Create new Email notifier when EMAIL_NOTIFICATIONS is true, the mailer and sender are read on every send so setting changes apply immediately
+func NewEmail(appName string, newMailer func() mailer.Mailer, sender func() mail.Address, recipients func(Event) ([]Recipient, error)) (*Email, error) {
+	if utils.GetEnv("EMAIL_NOTIFICATIONS", "false") != "true" {
+		return nil, fmt.Errorf("%w: EMAIL_NOTIFICATIONS is not true", ErrNotConfigured)
+	}
+	text, err := texttemplate.New("email").Funcs(templateFuncs).ParseFS(templates, "templates/email.txt")
+	if err != nil {
+		return nil, err
+	}
+	html, err := htmltemplate.New("email").Funcs(templateFuncs).ParseFS(templates, "templates/email.html")
+	if err != nil {
+		return nil, err
+	}
+	return &Email{appName, newMailer, sender, recipients, text, html}, nil
+}
+
+// Name of the email backend
+func (m *Email) Name() string {
+	return "email"
+}
+
+// The `Notify` function renders the email templates and sends one message to every recipient.
+// Price increases are not sent by email.
+func (m *Email) Notify(ctx context.Context, event Event) error {
+	if event.Type == PriceChange && !event.IsDrop() {
+		return nil
+	}
+	recipients, err := m.recipients(event)
+	if err != nil {
+		return err
+	}
+	client := m.mailer()
+	var errs []error
+	for _, recipient := range recipients {
+		if !includes(ctx, recipient.Id) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return errors.Join(append(errs, err)...)
+		}
+		message, err := m.Render(event, recipient)
+		if err != nil {
+			return err
+		}
+		if err := client.Send(message); err != nil {
+			errs = append(errs, recipientError(recipient.Id, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// The `NotifyBatch` function sends every recipient a single message, summarizing the events when there are more than one.
+func (m *Email) NotifyBatch(ctx context.Context, events []Event) error {
+	var order []string
+	recipients := map[string]Recipient{}
+	batches := map[string][]Event{}
+	for _, event := range events {
+		if event.Type == PriceChange && !event.IsDrop() {
+			continue
+		}
+		eventRecipients, err := m.recipients(event)
+		if err != nil {
+			return err
+		}
+		for _, recipient := range eventRecipients {
+			if !includes(ctx, recipient.Id) {
+				continue
+			}
+			if _, ok := recipients[recipient.Id]; !ok {
+				recipients[recipient.Id] = recipient
+				order = append(order, recipient.Id)
+			}
+			batches[recipient.Id] = append(batches[recipient.Id], event)
+		}
+	}
+	client := m.mailer()
+	var errs []error
+	for _, id := range order {
+		if err := ctx.Err(); err != nil {
+			return errors.Join(append(errs, err)...)
+		}
+		message, err := m.RenderSummary(batches[id], recipients[id])
+		if err != nil {
+			return err
+		}
+		if err := client.Send(message); err != nil {
+			errs = append(errs, recipientError(id, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// The `NotifyDigest` function mails the digest to its user.
+func (m *Email) NotifyDigest(ctx context.Context, digest Digest) error {
+	_, text, err := digest.Render()
+	if err != nil {
+		return err
+	}
+	message, err := m.render("digest_", map[string]any{"Digest": digest, "Text": text}, digest.User)
+	if err != nil {
+		return err
+	}
+	return m.mailer().Send(message)
+}
+
+// Render the email message of an event for a recipient
+func (m *Email) Render(event Event, recipient Recipient) (*mailer.Message, error) {
+	message, err := RenderMessage(m.Name(), event, recipient)
+	if err != nil {
+		return nil, err
+	}
+	return &mailer.Message{
+		From:    m.sender(),
+		To:      []mail.Address{{Name: recipient.Name, Address: recipient.Email}},
+		Subject: message.Title,
+		Text:    message.Body,
+		HTML:    message.HTML,
+	}, nil
+}
+
+// Render the email message of many events for a recipient, a single event is rendered like Render
+func (m *Email) RenderSummary(events []Event, recipient Recipient) (*mailer.Message, error) {
+	if len(events) == 1 {
+		return m.Render(events[0], recipient)
+	}
+	return m.render("summary_", map[string]any{"Events": events}, recipient)
+}
+
+// Execute the subject and body templates with the given prefix
+func (m *Email) render(prefix string, data map[string]any, recipient Recipient) (*mailer.Message, error) {
+	data["AppName"] = m.appName
+	data["Name"] = recipient.Name
+	if recipient.Name == "" {
+		data["Name"] = recipient.Email
+	}
+	var subject, text, html bytes.Buffer
+	if err := m.text.ExecuteTemplate(&subject, prefix+"subject", data); err != nil {
+		return nil, err
+	}
+	if err := m.text.ExecuteTemplate(&text, prefix+"body", data); err != nil {
+		return nil, err
+	}
+	if err := m.html.ExecuteTemplate(&html, prefix+"body", data); err != nil {
+		return nil, err
+	}
+	return &mailer.Message{
+		From:    m.sender(),
+		To:      []mail.Address{{Name: recipient.Name, Address: recipient.Email}},
+		Subject: subject.String(),
+		Text:    text.String(),
+		HTML:    html.String(),
+	}, nil
+}
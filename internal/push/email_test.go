@@ -0,0 +1,137 @@
+package push
+
+import (
+	"bufio"
+	"context"
+	"dilogger/internal/model"
+	"net"
+	"net/mail"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pocketbase/pocketbase/tools/mailer"
+)
+
+// Fake SMTP server keeping the messages it accepts, the recipients in reject are refused
+type smtpServer struct {
+	listener net.Listener
+	reject   string
+	mu       sync.Mutex
+	messages []string
+}
+
+func newSMTPServer(t *testing.T, reject string) *smtpServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &smtpServer{listener: listener, reject: reject}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+func (s *smtpServer) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+	reply("220 localhost")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(command, "RCPT") && s.reject != "" && strings.Contains(command, strings.ToUpper(s.reject)):
+			reply("550 no such user")
+		case strings.HasPrefix(command, "DATA"):
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, data.String())
+			s.mu.Unlock()
+			reply("250 queued")
+		case strings.HasPrefix(command, "QUIT"):
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+// Messages accepted so far
+func (s *smtpServer) sent() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.messages)
+}
+
+func TestEmailNotify(t *testing.T) {
+	server := newSMTPServer(t, "bob@example.com")
+	port := server.listener.Addr().(*net.TCPAddr).Port
+	recipients := []Recipient{
+		{Id: "alice", Name: "Alice", Email: "alice@example.com"},
+		{Id: "bob", Email: "bob@example.com"},
+	}
+	newEmail := func() (*Email, error) {
+		return NewEmail(
+			"Price Logger",
+			func() mailer.Mailer { return &mailer.SMTPClient{Host: "127.0.0.1", Port: port} },
+			func() mail.Address { return mail.Address{Name: "Prices", Address: "prices@example.com"} },
+			func(Event) ([]Recipient, error) { return recipients, nil },
+		)
+	}
+	if _, err := newEmail(); err == nil {
+		t.Fatal("email notifier created while EMAIL_NOTIFICATIONS is not set")
+	}
+	t.Setenv("EMAIL_NOTIFICATIONS", "true")
+	email, err := newEmail()
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := Event{Type: PriceChange, Product: model.Product{Id: "p1", Name: "Blue Kettle"}, OldPrice: 100, NewPrice: 80}
+	err = email.Notify(context.Background(), event)
+	// the refused recipient fails alone, so only that recipient is retried
+	if failed, ok := FailedRecipients(err); !ok || !slices.Equal(failed, []string{"bob"}) {
+		t.Errorf("Notify() error = %v, want a failure of bob only", err)
+	}
+	messages := server.sent()
+	if len(messages) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(messages))
+	}
+	message := messages[0]
+	for _, want := range []string{"From: Prices <prices@example.com>", "To: \"Alice\" <alice@example.com>", "Blue Kettle", "text/html"} {
+		if !strings.Contains(message, want) {
+			t.Errorf("message does not contain %q:\n%s", want, message)
+		}
+	}
+	// price increases are not sent by email
+	event.NewPrice = 120
+	if err := email.Notify(context.Background(), event); err != nil || len(server.sent()) != 1 {
+		t.Errorf("price increase: error = %v, messages = %d", err, len(server.sent()))
+	}
+}
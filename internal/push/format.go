@@ -0,0 +1,77 @@
+package push
+
+import (
+	"dilogger/internal/model"
+	"fmt"
+	"strings"
+)
+
+// Template helpers shared by the notification templates
+var templateFuncs = map[string]any{
+	"inr":     FormatINR,
+	"percent": FormatPercent,
+	"change":  FormatChange,
+	"mrkdwn":  strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace,
+	"link": func(productId string) string {
+		return Event{Product: model.Product{Id: productId}}.Link()
+	},
+	"section": func(title string, kind string, items []DigestItem) map[string]any {
+		return map[string]any{"Title": title, "Kind": kind, "Items": items}
+	},
+}
+
+// The `FormatINR` function formats an amount in rupees with the Indian digit grouping, like ₹1,23,456.00
+func FormatINR(amount float64) string {
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	whole, fraction, _ := strings.Cut(fmt.Sprintf("%.2f", amount), ".")
+	// the last three digits are grouped together, the others in pairs
+	if len(whole) > 3 {
+		head, tail := whole[:len(whole)-3], whole[len(whole)-3:]
+		var groups []string
+		for len(head) > 2 {
+			groups = append([]string{head[len(head)-2:]}, groups...)
+			head = head[:len(head)-2]
+		}
+		groups = append([]string{head}, groups...)
+		whole = strings.Join(groups, ",") + "," + tail
+	}
+	return sign + "₹" + whole + "." + fraction
+}
+
+// The `FormatPercent` function formats a percentage with its sign, like -12.5%
+func FormatPercent(value float64) string {
+	return fmt.Sprintf("%+.1f%%", value)
+}
+
+// The `FormatChange` function describes the prices of an event in plain text, like ₹1,000.00 → ₹900.00 (-10.0%),
+// followed by the warning of a misleading discount
+func FormatChange(event Event) string {
+	switch event.Type {
+	case PriceChange:
+		change := fmt.Sprintf("%s → %s (%s)", FormatINR(event.OldPrice), FormatINR(event.NewPrice), FormatPercent(event.ChangePercent()))
+		if warning := event.DiscountWarning(); warning != "" {
+			change += ", " + warning
+		}
+		return change
+	case BackInStock:
+		return fmt.Sprintf("%s, %d in stock", FormatINR(event.NewPrice), event.Product.Stock)
+	case Sale:
+		if len(event.Drops) == 0 {
+			return fmt.Sprintf("%d products dropped", event.DropCount)
+		}
+		return fmt.Sprintf("%d products dropped, up to %s", event.DropCount, FormatPercent(event.Drops[0].ChangePercent()))
+	case Listed:
+		return "listed at " + FormatINR(event.NewPrice)
+	case Delisted:
+		return "no longer listed, last price " + FormatINR(event.NewPrice)
+	case Budget:
+		return fmt.Sprintf("basket %s, %s under the %s budget", FormatINR(event.NewPrice), FormatINR(event.Difference()), FormatINR(event.OldPrice))
+	case PriceProtection:
+		return fmt.Sprintf("%s, %s below the %s paid", FormatINR(event.NewPrice), FormatINR(event.Difference()), FormatINR(event.OldPrice))
+	}
+	return FormatINR(event.NewPrice)
+}
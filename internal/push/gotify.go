@@ -0,0 +1,62 @@
+package push
+
+import (
+	"context"
+	"dilogger/internal/utils"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Priorities of Gotify messages by event priority
+var gotifyPriorities = map[int]int{PriorityLow: 2, PriorityDefault: 5, PriorityHigh: 8}
+
+// Gotify sends events as messages of a Gotify application
+type Gotify struct {
+	url     string
+	token   string
+	bigDrop float64
+	client  *http.Client
+}
+
+// Create new Gotify notifier from GOTIFY_URL and the GOTIFY_TOKEN of the application, fails when one of them is not set
+func NewGotify() (*Gotify, error) {
+	url := utils.GetEnv("GOTIFY_URL", "")
+	token := utils.GetEnv("GOTIFY_TOKEN", "")
+	if url == "" || token == "" {
+		return nil, fmt.Errorf("%w: GOTIFY_URL or GOTIFY_TOKEN is not set", ErrNotConfigured)
+	}
+	if err := checkURL("GOTIFY_URL", url); err != nil {
+		return nil, err
+	}
+	return &Gotify{
+		url:     strings.TrimSuffix(url, "/"),
+		token:   token,
+		bigDrop: float64(utils.GetEnvInt("NOTIFY_BIG_DROP", 10)),
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Name of the Gotify backend
+func (g *Gotify) Name() string {
+	return "gotify"
+}
+
+// The `Notify` function creates a message whose click url opens the price chart on Android.
+func (g *Gotify) Notify(ctx context.Context, event Event) error {
+	message, err := RenderMessage(g.Name(), event, Recipient{})
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, g.client, g.url+"/message", map[string]string{"X-Gotify-Key": g.token}, map[string]any{
+		"title":    message.Title,
+		"message":  message.Body,
+		"priority": gotifyPriorities[event.Priority(g.bigDrop)],
+		"extras": map[string]any{
+			"client::notification": map[string]any{
+				"click": map[string]string{"url": event.Link()},
+			},
+		},
+	})
+}
@@ -0,0 +1,166 @@
+package push
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// Kinds of deliveries
+const (
+	KindEvent  = "event"
+	KindBatch  = "batch"
+	KindDigest = "digest"
+)
+
+// Job is the delivery of an event, a batch of events or a digest to a single backend, stored to be retried when it fails
+type Job struct {
+	// Id of the stored job, empty until it is stored
+	Id       string  `json:"id,omitempty"`
+	Backend  string  `json:"backend"`
+	Kind     string  `json:"kind"`
+	Events   []Event `json:"events,omitempty"`
+	Digest   *Digest `json:"digest,omitempty"`
+	Attempts int     `json:"attempts"`
+	// Recipient limits the delivery to a single recipient or channel of the backend, empty for all of them
+	Recipient string `json:"recipient,omitempty"`
+}
+
+// RecipientError is the failure of a delivery to a single recipient or channel of a backend
+type RecipientError struct {
+	Recipient string
+	Err       error
+	// Pending lists the events left to deliver to the recipient when some of its events were delivered, nil when none was
+	Pending []Event
+}
+
+func (e *RecipientError) Error() string {
+	return e.Recipient + ": " + e.Err.Error()
+}
+
+func (e *RecipientError) Unwrap() error {
+	return e.Err
+}
+
+// Wrap the error of a delivery to a single recipient or channel, nil when there is no error
+func recipientError(recipient string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &RecipientError{Recipient: recipient, Err: err}
+}
+
+// The `FailedRecipients` function lists the recipients of the RecipientError joined in err.
+// ok is false when err holds any other error, the whole delivery failed then.
+func FailedRecipients(err error) (recipients []string, ok bool) {
+	if recipientErr, ok := err.(*RecipientError); ok {
+		return []string{recipientErr.Recipient}, true
+	}
+	joined, isJoined := err.(interface{ Unwrap() []error })
+	if !isJoined {
+		return nil, false
+	}
+	for _, err := range joined.Unwrap() {
+		failed, ok := FailedRecipients(err)
+		if !ok {
+			return nil, false
+		}
+		recipients = append(recipients, failed...)
+	}
+	return recipients, true
+}
+
+// The `PendingEvents` function returns the events left to deliver to each recipient of the RecipientError joined in err
+// which failed after some of its events were delivered.
+func PendingEvents(err error) map[string][]Event {
+	pending := map[string][]Event{}
+	var walk func(err error)
+	walk = func(err error) {
+		if recipientErr, ok := err.(*RecipientError); ok {
+			if recipientErr.Pending != nil {
+				pending[recipientErr.Recipient] = recipientErr.Pending
+			}
+			return
+		}
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			for _, err := range joined.Unwrap() {
+				walk(err)
+			}
+		}
+	}
+	walk(err)
+	return pending
+}
+
+// Type of the delivered event, or the kind of the job for batches and digests
+func (j Job) EventType() string {
+	if j.Kind == KindEvent && len(j.Events) > 0 {
+		return j.Events[0].Type
+	}
+	return j.Kind
+}
+
+// Product of the delivered event, empty for batches and digests
+func (j Job) Product() string {
+	if j.Kind == KindEvent && len(j.Events) > 0 {
+		return j.Events[0].Product.Id
+	}
+	return ""
+}
+
+// Run the job with the backend
+func (j Job) run(ctx context.Context, n Notifier) error {
+	switch j.Kind {
+	case KindBatch:
+		return n.(BatchNotifier).NotifyBatch(ctx, j.Events)
+	case KindDigest:
+		return n.(DigestNotifier).NotifyDigest(ctx, *j.Digest)
+	}
+	return n.Notify(ctx, j.Events[0])
+}
+
+type recipientKey struct{}
+
+// Create a context limiting the delivery to the recipient, unless it is empty
+func withRecipient(ctx context.Context, recipient string) context.Context {
+	if recipient == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, recipientKey{}, recipient)
+}
+
+// Check whether the delivery running with the context includes the recipient, a retry only includes the recipient which failed
+func includes(ctx context.Context, recipient string) bool {
+	only, ok := ctx.Value(recipientKey{}).(string)
+	return !ok || only == recipient
+}
+
+// Ids returned by the provider during a delivery
+type responseIds struct {
+	mu  sync.Mutex
+	ids []string
+}
+
+type responseKey struct{}
+
+// The `SetResponseID` function records the id returned by the provider for the delivery running with the context.
+func SetResponseID(ctx context.Context, id string) {
+	if r, ok := ctx.Value(responseKey{}).(*responseIds); ok && id != "" {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.ids = append(r.ids, id)
+	}
+}
+
+// Create a context recording the ids returned by the providers
+func withResponseIds(ctx context.Context) (context.Context, *responseIds) {
+	r := &responseIds{}
+	return context.WithValue(ctx, responseKey{}, r), r
+}
+
+// Ids recorded during the delivery, comma separated
+func (r *responseIds) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return strings.Join(r.ids, ",")
+}
@@ -0,0 +1,198 @@
+package push
+
+import (
+	"bytes"
+	"dilogger/internal/model"
+	"dilogger/internal/series"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
+)
+
+// Channels whose messages are rendered from templates
+var MessageChannels = []string{"email", "telegram", "discord", "slack", "ntfy", "gotify", "webpush", "onesignal"}
+
+// Channels whose title and body are HTML
+var htmlChannels = []string{"telegram"}
+
+// Message of an event rendered for a channel, HTML is only rendered for emails
+type Message struct {
+	Title string
+	Body  string
+	HTML  string
+}
+
+// MessageTemplate replaces the default templates of a channel for an event type, or for every event when Event is empty.
+// An empty Title or HTML keeps the default one.
+type MessageTemplate struct {
+	Channel string `json:"channel"`
+	Event   string `json:"event"`
+	Title   string `json:"title"`
+	Body    string `json:"body"`
+	HTML    string `json:"html"`
+}
+
+// Data of the message templates, the fields and methods of the event are available directly
+type MessageData struct {
+	Event
+	// Name of the recipient, its email when it has no name, empty for the channels without recipient
+	Name    string
+	AppName string
+}
+
+// Default message templates of the channels
+var (
+	textMessages = texttemplate.Must(texttemplate.New("messages").Funcs(templateFuncs).ParseFS(templates, "templates/messages.txt"))
+	htmlMessages = htmltemplate.Must(htmltemplate.New("messages").Funcs(templateFuncs).ParseFS(templates, "templates/messages.html"))
+)
+
+// Source of the custom templates
+var templateSource struct {
+	mu     sync.RWMutex
+	lookup func(channel string, eventType string) (MessageTemplate, bool)
+}
+
+// The `SetTemplateSource` function sets the function looking up the custom template of a channel for an event type.
+func SetTemplateSource(lookup func(channel string, eventType string) (MessageTemplate, bool)) {
+	templateSource.mu.Lock()
+	defer templateSource.mu.Unlock()
+	templateSource.lookup = lookup
+}
+
+// The `RenderMessage` function renders the title and body of an event for a channel and a recipient,
+// with the custom template of the channel when there is one.
+func RenderMessage(channel string, event Event, recipient Recipient) (Message, error) {
+	custom := MessageTemplate{Channel: channel, Event: event.Type}
+	templateSource.mu.RLock()
+	if templateSource.lookup != nil {
+		if found, ok := templateSource.lookup(channel, event.Type); ok {
+			custom = found
+		}
+	}
+	templateSource.mu.RUnlock()
+	title, body, html, err := custom.parse()
+	if err != nil {
+		return Message{}, err
+	}
+	data := MessageData{Event: event, Name: recipient.Name, AppName: AppName()}
+	if data.Name == "" {
+		data.Name = recipient.Email
+	}
+	var message Message
+	if message.Title, err = execute(title, data); err != nil {
+		return Message{}, err
+	}
+	if message.Body, err = execute(body, data); err != nil {
+		return Message{}, err
+	}
+	if html != nil {
+		if message.HTML, err = execute(html, data); err != nil {
+			return Message{}, err
+		}
+	} else if channel == "email" {
+		// a custom body without HTML is sent as preformatted text
+		message.HTML = "<pre>" + htmltemplate.HTMLEscapeString(message.Body) + "</pre>"
+	}
+	return message, nil
+}
+
+// The `Check` function parses the template and renders it with a sample event, so mistakes are found when the template is saved.
+func (t MessageTemplate) Check() error {
+	if !slices.Contains(MessageChannels, t.Channel) {
+		return fmt.Errorf("invalid channel '%s'", t.Channel)
+	}
+	eventType := t.Event
+	if eventType == "" {
+		eventType = PriceChange
+	}
+	event := Event{
+		Type:     eventType,
+		Product:  model.Product{Id: "sample", Name: "Sample product", Price: 900, Stock: 3},
+		OldPrice: 1000,
+		NewPrice: 900,
+		Time:     time.Now(),
+	}
+	switch eventType {
+	case Sale:
+		event = SaleEvent([]Event{event, event}, 5)
+	case PriceChange:
+		event.DiscountQuality = series.DiscountInflated
+	case PriceProtection:
+		until := event.Time.AddDate(0, 0, 30)
+		event.ProtectedUntil = &until
+	}
+	title, body, html, err := t.parse()
+	if err != nil {
+		return err
+	}
+	data := MessageData{Event: event, Name: "Sample user", AppName: AppName()}
+	for _, tmpl := range []executor{title, body, html} {
+		if tmpl == nil {
+			continue
+		}
+		if _, err := execute(tmpl, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Template of a message part, either a text or an HTML template
+type executor interface {
+	Execute(w io.Writer, data any) error
+}
+
+// Parse the parts of the template, the empty parts are replaced by the defaults of the channel
+func (t MessageTemplate) parse() (title executor, body executor, html executor, err error) {
+	isHTML := slices.Contains(htmlChannels, t.Channel)
+	parse := func(name string, text string) (executor, error) {
+		if text == "" {
+			return defaultTemplate(t.Channel, name, isHTML), nil
+		}
+		if isHTML {
+			return htmltemplate.New(name).Funcs(templateFuncs).Parse(text)
+		}
+		return texttemplate.New(name).Funcs(templateFuncs).Parse(text)
+	}
+	if title, err = parse("title", t.Title); err != nil {
+		return nil, nil, nil, err
+	}
+	if body, err = parse("body", t.Body); err != nil {
+		return nil, nil, nil, err
+	}
+	if t.Channel != "email" {
+		return title, body, nil, nil
+	}
+	switch {
+	case t.HTML != "":
+		html, err = htmltemplate.New("html").Funcs(templateFuncs).Parse(t.HTML)
+	case t.Body == "":
+		html = htmlMessages.Lookup("email_html")
+	}
+	return title, body, html, err
+}
+
+// Get the default template of a message part for a channel, falling back to the template shared by the channels
+func defaultTemplate(channel string, name string, isHTML bool) executor {
+	if isHTML {
+		return htmlMessages.Lookup(channel + "_" + name)
+	}
+	if tmpl := textMessages.Lookup(channel + "_" + name); tmpl != nil {
+		return tmpl
+	}
+	return textMessages.Lookup(name)
+}
+
+// Execute a template and trim the result
+func execute(tmpl executor, data any) (string, error) {
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out.String()), nil
+}
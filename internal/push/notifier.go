@@ -0,0 +1,437 @@
+package push
+
+import (
+	"context"
+	"dilogger/internal/model"
+	"dilogger/internal/series"
+	"dilogger/internal/utils"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Types of notification events
+const (
+	PriceChange     = "price_change"
+	BackInStock     = "back_in_stock"
+	OutOfStock      = "out_of_stock"
+	Sale            = "sale"
+	PriceProtection = "price_protection"
+	Listed          = "listed"
+	Delisted        = "delisted"
+	Budget          = "budget"
+)
+
+// Target reaching every subscriber of a backend who has no alert rule on it
+const AllSubscribers = "*"
+
+// Event types which can be notified
+var EventTypes = []string{PriceChange, BackInStock, OutOfStock, Sale, PriceProtection, Listed, Delisted, Budget}
+
+// Event sent to the notification backends
+type Event struct {
+	Type     string        `json:"type"`
+	Product  model.Product `json:"product"`
+	OldPrice float64       `json:"old_price"`
+	NewPrice float64       `json:"new_price"`
+	Time     time.Time     `json:"time"`
+	// Targets lists the users to notify on each backend, nil sends the event to every backend and subscriber.
+	// AllSubscribers in the users of a backend sends it to its subscribers without an alert rule on the backend too.
+	Targets map[string][]string `json:"targets,omitempty"`
+	// Drops lists the largest price drops of a sale event and DropCount counts all of them
+	Drops     []Event `json:"drops,omitempty"`
+	DropCount int     `json:"drop_count,omitempty"`
+	// ProtectedUntil ends the price protection window of a purchase, OldPrice is then the price paid.
+	// The OldPrice of a budget event is the budget and its NewPrice the basket total of the wishlist in Product.Url.
+	ProtectedUntil *time.Time `json:"protected_until,omitempty"`
+	// DiscountQuality rates a price drop against the price history, see series.DiscountQuality
+	DiscountQuality string `json:"discount_quality,omitempty"`
+}
+
+// Check whether the event is a price drop
+func (e Event) IsDrop() bool {
+	return e.Type == PriceChange && e.OldPrice > 0 && e.NewPrice < e.OldPrice
+}
+
+// Change of the price in percent of the old price
+func (e Event) ChangePercent() float64 {
+	if e.OldPrice == 0 {
+		return 0
+	}
+	return (e.NewPrice - e.OldPrice) / e.OldPrice * 100
+}
+
+// Warning about a discount which may be misleading, empty for genuine discounts and other events
+func (e Event) DiscountWarning() string {
+	switch e.DiscountQuality {
+	case series.DiscountInflated:
+		return "the price was raised shortly before this discount"
+	case series.DiscountAboveMedian:
+		return "the new price is not below the recent median price"
+	}
+	return ""
+}
+
+// Amount by which the new price is below the old price
+func (e Event) Difference() float64 {
+	return e.OldPrice - e.NewPrice
+}
+
+// Check whether the event is delivered by the backend
+func (e Event) Targeted(backend string) bool {
+	if e.Targets == nil {
+		return true
+	}
+	_, ok := e.Targets[backend]
+	return ok
+}
+
+// Get the users targeted on the backend, all is true when the subscribers without an alert rule on the backend receive the event too
+func (e Event) Users(backend string) (users []string, all bool) {
+	if e.Targets == nil {
+		return nil, true
+	}
+	for _, user := range e.Targets[backend] {
+		if user == AllSubscribers {
+			all = true
+		} else {
+			users = append(users, user)
+		}
+	}
+	return users, all
+}
+
+// Short title of the event
+func (e Event) Title() string {
+	switch {
+	case e.IsDrop():
+		return "Price drop"
+	case e.Type == PriceChange:
+		return "Price change"
+	case e.Type == BackInStock:
+		return "Back in stock"
+	case e.Type == OutOfStock:
+		return "Out of stock"
+	case e.Type == Sale:
+		return "Sale"
+	case e.Type == PriceProtection:
+		return "Price protection"
+	case e.Type == Listed:
+		return "Added to wishlist"
+	case e.Type == Delisted:
+		return "No longer listed"
+	case e.Type == Budget:
+		return "Under budget"
+	}
+	return e.Type
+}
+
+// Priorities of the events on push backends
+const (
+	PriorityLow = iota
+	PriorityDefault
+	PriorityHigh
+)
+
+// The `Priority` function ranks a sale, a price protection and a drop of at least bigDrop percent as high, other drops and restocks as default and the rest as low.
+func (e Event) Priority(bigDrop float64) int {
+	switch {
+	case e.IsDrop() && -e.ChangePercent() >= bigDrop, e.Type == Sale, e.Type == PriceProtection:
+		return PriorityHigh
+	case e.IsDrop(), e.Type == BackInStock:
+		return PriorityDefault
+	}
+	return PriorityLow
+}
+
+// Link to the product chart in the web UI, or to the web UI for the events without product
+func (e Event) Link() string {
+	if e.Product.Id == "" {
+		return AppURL() + "/"
+	}
+	return AppURL() + "/?product=" + e.Product.Id
+}
+
+// URL of the web UI without the trailing slash
+func AppURL() string {
+	return strings.TrimSuffix(utils.GetEnv("PB_APP_URL", "http://localhost:8090"), "/")
+}
+
+// Name of the app shown in the messages
+func AppName() string {
+	return utils.GetEnv("PB_APP_NAME", "Price Logger")
+}
+
+// Notifier is a backend able to deliver notification events
+type Notifier interface {
+	// Name identifies the backend in logs and statistics
+	Name() string
+	// Notify delivers a single event
+	Notify(ctx context.Context, event Event) error
+}
+
+// BatchNotifier is a backend able to deliver many events in a single message
+type BatchNotifier interface {
+	Notifier
+	// NotifyBatch delivers the events held during a scrape run together
+	NotifyBatch(ctx context.Context, events []Event) error
+}
+
+// Result of delivering an event to a single backend
+type Result struct {
+	Backend    string        `json:"backend"`
+	Error      string        `json:"error,omitempty"`
+	ResponseID string        `json:"response_id,omitempty"`
+	Duration   time.Duration `json:"duration"`
+	// Failed lists the recipients or channels which failed when the delivery to the others succeeded, see RecipientError
+	Failed []string `json:"failed,omitempty"`
+	// Pending lists the events left to deliver to the failed recipients or channels which received some of them
+	Pending map[string][]Event `json:"-"`
+}
+
+// Delivery statistics of a single backend
+type BackendStats struct {
+	Sent         int           `json:"sent"`
+	Failed       int           `json:"failed"`
+	LastError    string        `json:"last_error,omitempty"`
+	LastDuration time.Duration `json:"last_duration"`
+	LastSent     time.Time     `json:"last_sent"`
+}
+
+// Dispatcher fans out every event to all the configured backends
+type Dispatcher struct {
+	notifiers []Notifier
+	timeout   time.Duration
+	logger    *slog.Logger
+	mu        sync.Mutex
+	stats     map[string]*BackendStats
+	holds     int
+	pending   []Event
+	onResult  func(Job, Result)
+}
+
+// Create new Dispatcher with the given backends
+func NewDispatcher(logger *slog.Logger, notifiers ...Notifier) *Dispatcher {
+	d := &Dispatcher{
+		timeout: 30 * time.Second,
+		logger:  logger,
+		stats:   map[string]*BackendStats{},
+	}
+	for _, n := range notifiers {
+		d.Add(n)
+	}
+	return d
+}
+
+// ErrNotConfigured is wrapped by the constructors of the backends whose settings are not set
+var ErrNotConfigured = errors.New("not configured")
+
+// Check that a setting is an absolute http or https URL
+func checkURL(setting string, value string) error {
+	parsed, err := url.Parse(value)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("invalid %s url '%s'", setting, value)
+	}
+	return nil
+}
+
+// The `AddConfigured` function adds the backend returned by its constructor. A backend which is not configured
+// is skipped, while an error in its settings is logged as an error since its notifications are lost.
+func (d *Dispatcher) AddConfigured(name string, n Notifier, err error) {
+	switch {
+	case err == nil:
+		d.Add(n)
+	case errors.Is(err, ErrNotConfigured):
+		d.logger.Info(name+" notifications disabled", "reason", err.Error())
+	default:
+		d.logger.Error(name+" notifications disabled, invalid settings", "error", err.Error())
+	}
+}
+
+// Add a backend to the dispatcher
+func (d *Dispatcher) Add(n Notifier) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.notifiers = append(d.notifiers, n)
+	d.stats[n.Name()] = &BackendStats{}
+}
+
+// Set the function receiving every job with its result once it ran, the attempts of the job include that run
+func (d *Dispatcher) OnResult(onResult func(Job, Result)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.onResult = onResult
+}
+
+// Names of the configured backends
+func (d *Dispatcher) Backends() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var names []string
+	for _, n := range d.notifiers {
+		names = append(names, n.Name())
+	}
+	return names
+}
+
+// The `Send` function delivers the event to every backend concurrently and waits for all of them.
+// While the dispatcher is held the batch backends are skipped and receive the event on Flush.
+func (d *Dispatcher) Send(event Event) []Result {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	d.mu.Lock()
+	notifiers := append([]Notifier(nil), d.notifiers...)
+	held := d.holds > 0
+	if held {
+		d.pending = append(d.pending, event)
+	}
+	d.mu.Unlock()
+
+	var jobs []Job
+	for _, n := range notifiers {
+		if _, ok := n.(BatchNotifier); (ok && held) || !event.Targeted(n.Name()) {
+			continue
+		}
+		jobs = append(jobs, Job{Backend: n.Name(), Kind: KindEvent, Events: []Event{event}})
+	}
+	return d.deliverAll(notifiers, jobs)
+}
+
+// Hold the events for the batch backends until the matching Flush, holds can be nested
+func (d *Dispatcher) Hold() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.holds++
+}
+
+// The `Flush` function releases a hold and, once the last hold is released, delivers the held events to every batch backend.
+func (d *Dispatcher) Flush() []Result {
+	d.mu.Lock()
+	if d.holds > 0 {
+		d.holds--
+	}
+	if d.holds > 0 || len(d.pending) == 0 {
+		d.mu.Unlock()
+		return nil
+	}
+	events := d.pending
+	d.pending = nil
+	notifiers := append([]Notifier(nil), d.notifiers...)
+	d.mu.Unlock()
+
+	var jobs []Job
+	for _, n := range notifiers {
+		if _, ok := n.(BatchNotifier); !ok {
+			continue
+		}
+		job := Job{Backend: n.Name(), Kind: KindBatch}
+		for _, event := range events {
+			if event.Targeted(n.Name()) {
+				job.Events = append(job.Events, event)
+			}
+		}
+		if len(job.Events) > 0 {
+			jobs = append(jobs, job)
+		}
+	}
+	return d.deliverAll(notifiers, jobs)
+}
+
+// The `SendDigest` function delivers the digest to the listed backends which are able to send digests.
+func (d *Dispatcher) SendDigest(digest Digest, backends []string) []Result {
+	d.mu.Lock()
+	notifiers := append([]Notifier(nil), d.notifiers...)
+	d.mu.Unlock()
+
+	var jobs []Job
+	for _, n := range notifiers {
+		if _, ok := n.(DigestNotifier); ok && slices.Contains(backends, n.Name()) {
+			jobs = append(jobs, Job{Backend: n.Name(), Kind: KindDigest, Digest: &digest})
+		}
+	}
+	return d.deliverAll(notifiers, jobs)
+}
+
+// The `Retry` function runs a stored job again with its backend, which fails when the backend is no longer configured.
+func (d *Dispatcher) Retry(job Job) Result {
+	d.mu.Lock()
+	notifiers := append([]Notifier(nil), d.notifiers...)
+	d.mu.Unlock()
+	return d.deliverAll(notifiers, []Job{job})[0]
+}
+
+// Run the jobs concurrently with their backends and wait for all of them
+func (d *Dispatcher) deliverAll(notifiers []Notifier, jobs []Job) []Result {
+	results := make([]Result, len(jobs))
+	var wg sync.WaitGroup
+	for i, job := range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = d.deliver(notifiers, job)
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// Run a job with its backend, record the statistics of the backend and pass the result to the result function
+func (d *Dispatcher) deliver(notifiers []Notifier, job Job) Result {
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	ctx, responseIds := withResponseIds(withRecipient(ctx, job.Recipient))
+	start := time.Now()
+	err := fmt.Errorf("backend %s is not configured", job.Backend)
+	for _, n := range notifiers {
+		if n.Name() == job.Backend {
+			err = job.run(ctx, n)
+			break
+		}
+	}
+	result := Result{Backend: job.Backend, ResponseID: responseIds.String(), Duration: time.Since(start)}
+	job.Attempts++
+
+	d.mu.Lock()
+	if stats, ok := d.stats[job.Backend]; ok {
+		stats.LastDuration = result.Duration
+		stats.LastSent = start
+		if err != nil {
+			stats.Failed++
+			stats.LastError = err.Error()
+		} else {
+			stats.Sent++
+		}
+	}
+	onResult := d.onResult
+	d.mu.Unlock()
+
+	if err != nil {
+		result.Error = err.Error()
+		result.Failed, _ = FailedRecipients(err)
+		result.Pending = PendingEvents(err)
+		d.logger.Error("notification failed", "backend", result.Backend, "event", job.EventType(), "attempt", job.Attempts, "error", result.Error, "duration", result.Duration)
+	} else {
+		d.logger.Info("notification sent", "backend", result.Backend, "event", job.EventType(), "attempt", job.Attempts, "duration", result.Duration)
+	}
+	if onResult != nil {
+		onResult(job, result)
+	}
+	return result
+}
+
+// Get a copy of the delivery statistics of every backend
+func (d *Dispatcher) Stats() map[string]BackendStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	stats := map[string]BackendStats{}
+	for name, s := range d.stats {
+		stats[name] = *s
+	}
+	return stats
+}
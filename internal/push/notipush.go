@@ -2,69 +2,175 @@ package push
 
 import (
 	"context"
-	"dilogger/internal/model"
 	"dilogger/internal/utils"
 	"encoding/json"
-	"log"
+	"fmt"
+	"log/slog"
 	"strings"
+	"time"
 
 	"github.com/OneSignal/onesignal-go-api/v2"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
 
-// The type Output has two fields, Id and External_id, with corresponding JSON tags.
+// The type Output has the fields Id, External_id and Errors, with corresponding JSON tags.
 type Output struct {
 	Id          string `json:"id"`
 	External_id string `json:"external_id"`
+	Errors      any    `json:"errors"`
 }
 
 type OneSignalApp struct {
-	id       string
-	client   *onesignal.APIClient
-	authCtx  context.Context
-	template string
-	segments []string
+	id          string
+	client      *onesignal.APIClient
+	key         string
+	segments    []string
+	externalIds func(users []string, all bool) (externalIds []string, segments bool, err error)
 }
 
-// Create new OneSignal App
-func NewNotificationApp() *OneSignalApp {
+// Create new notification dispatcher with every backend configured in the environment
+func NewNotificationApp(logger *slog.Logger, onDelivery func(Delivery)) *Dispatcher {
+	dispatcher := NewDispatcher(logger)
+	webhook, err := NewWebhook(onDelivery)
+	dispatcher.AddConfigured("webhook", webhook, err)
+	telegram, err := NewTelegram()
+	dispatcher.AddConfigured("telegram", telegram, err)
+	appName := utils.GetEnv("PB_APP_NAME", "Price Logger")
+	discord, err := NewDiscord(appName, utils.GetEnv("DISCORD_WEBHOOKS", ""))
+	dispatcher.AddConfigured("discord", discord, err)
+	slack, err := NewSlack(appName, utils.GetEnv("SLACK_WEBHOOKS", ""))
+	dispatcher.AddConfigured("slack", slack, err)
+	ntfy, err := NewNtfy()
+	dispatcher.AddConfigured("ntfy", ntfy, err)
+	gotify, err := NewGotify()
+	dispatcher.AddConfigured("gotify", gotify, err)
+	return dispatcher
+}
+
+// Create new OneSignal App, fails when the credentials are not configured.
+// The external ids of the users targeted by the alert rules are looked up on every send, users without one are skipped.
+// The lookup returns segments true when the event reaches every subscriber, it is then sent to the OS_SEGMENT segments.
+func NewOneSignalApp(externalIds func(users []string, all bool) ([]string, bool, error)) (*OneSignalApp, error) {
+	id := utils.GetEnv("OS_APP_ID", "")
+	key := utils.GetEnv("OS_APP_KEY", "")
+	if id == "" || key == "" {
+		return nil, fmt.Errorf("%w: OS_APP_ID or OS_APP_KEY is not set", ErrNotConfigured)
+	}
 	return &OneSignalApp{
-		utils.GetEnv("OS_APP_ID"),
+		id,
 		onesignal.NewAPIClient(onesignal.NewConfiguration()),
-		context.WithValue(context.Background(), onesignal.UserAuth, utils.GetEnv("OS_APP_KEY")),
-		utils.GetEnv("OS_TEMPLATE_ID"),
-		strings.Split(utils.GetEnv("OS_SEGMENT"), ","),
+		key,
+		strings.Split(utils.GetEnv("OS_SEGMENT", "Total Subscriptions"), ","),
+		externalIds,
+	}, nil
+}
+
+// The `OneSignalToken` function signs the identity verification token of a OneSignal external id with the OS_IDENTITY_KEY private key,
+// the ES256 key generated in the OneSignal dashboard. It fails with ErrNotConfigured when the key is not set.
+func OneSignalToken(externalId string) (string, error) {
+	pemKey := strings.ReplaceAll(utils.GetEnv("OS_IDENTITY_KEY", ""), `\n`, "\n")
+	if pemKey == "" {
+		return "", fmt.Errorf("%w: OS_IDENTITY_KEY is not set", ErrNotConfigured)
+	}
+	key, err := jwt.ParseECPrivateKeyFromPEM([]byte(pemKey))
+	if err != nil {
+		return "", fmt.Errorf("invalid OS_IDENTITY_KEY: %w", err)
 	}
+	claims := jwt.MapClaims{
+		"iss":      utils.GetEnv("OS_APP_ID", ""),
+		"exp":      time.Now().Add(24 * time.Hour).Unix(),
+		"identity": map[string]string{"external_id": externalId},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodES256, claims).SignedString(key)
+}
+
+// Name of the OneSignal backend
+func (app *OneSignalApp) Name() string {
+	return "onesignal"
 }
 
-// The `Send` function sends a push notification using OneSignal with custom data and verifies the notification's external ID.
-func (app *OneSignalApp) Send(data model.Product) {
-	var input map[string]any
+// The `Notify` function sends the rendered event as a push notification using OneSignal.
+// An event targeted by alert rules is sent to the external ids of its users, an event reaching every subscriber to the OS_SEGMENT segments.
+func (app *OneSignalApp) Notify(ctx context.Context, event Event) error {
+	message, err := RenderMessage(app.Name(), event, Recipient{})
+	if err != nil {
+		return err
+	}
 	noti := *onesignal.NewNotification(app.id)
+	externalIds, segments, err := app.externalIds(event.Users(app.Name()))
+	if err != nil {
+		return err
+	}
+	if segments {
+		noti.SetIncludedSegments(app.segments)
+	} else {
+		if len(externalIds) == 0 {
+			return nil
+		}
+		noti.SetIncludeAliases(onesignal.PlayerNotificationTargetIncludeAliases{
+			AdditionalProperties: map[string]any{"external_id": externalIds},
+		})
+		noti.SetTargetChannel("push")
+	}
+	noti.SetHeadings(onesignal.StringMap{En: &message.Title})
+	noti.SetContents(onesignal.StringMap{En: &message.Body})
+	noti.SetUrl(event.Link())
+	noti.SetData(map[string]any{
+		"event":     event.Type,
+		"product":   event.Product.Id,
+		"old_price": event.OldPrice,
+		"new_price": event.NewPrice,
+	})
+	return app.create(ctx, noti)
+}
+
+// The `NotifyDigest` function sends the title of the digest to the OneSignal subscriptions of its user, a click opens the web UI.
+func (app *OneSignalApp) NotifyDigest(ctx context.Context, digest Digest) error {
+	externalIds, _, err := app.externalIds([]string{digest.User.Id}, false)
+	if err != nil || len(externalIds) == 0 {
+		return err
+	}
+	title, text, err := digest.Render()
+	if err != nil {
+		return err
+	}
+	body := truncate(text, 200)
+	noti := *onesignal.NewNotification(app.id)
+	noti.SetIncludeAliases(onesignal.PlayerNotificationTargetIncludeAliases{
+		AdditionalProperties: map[string]any{"external_id": externalIds},
+	})
+	noti.SetTargetChannel("push")
+	noti.SetHeadings(onesignal.StringMap{En: &title})
+	noti.SetContents(onesignal.StringMap{En: &body})
+	noti.SetUrl(AppURL() + "/")
+	return app.create(ctx, noti)
+}
+
+// Create the notification with OneSignal and verify the notification's external ID
+func (app *OneSignalApp) create(ctx context.Context, noti onesignal.Notification) error {
 	eid := uuid.New().String()
 	noti.SetExternalId(eid)
 	noti.SetIsIos(false)
 	noti.SetName("API Notification")
-	noti.SetTemplateId(app.template)
-	noti.SetIncludedSegments(app.segments)
-	_data, _ := json.Marshal(data)
-	json.Unmarshal(_data, &input)
-	noti.SetCustomData(input)
-
-	_, r, err := app.client.DefaultApi.CreateNotification(app.authCtx).Notification(noti).Execute()
 
+	authCtx := context.WithValue(ctx, onesignal.UserAuth, app.key)
+	_, r, err := app.client.DefaultApi.CreateNotification(authCtx).Notification(noti).Execute()
 	if err != nil {
-		log.Fatalln(err)
+		return err
 	}
+	defer r.Body.Close()
 
 	var out Output
-	err = json.NewDecoder(r.Body).Decode(&out)
-
-	if err != nil {
-		log.Fatalln(err)
+	if err := json.NewDecoder(r.Body).Decode(&out); err != nil {
+		return err
+	}
+	if out.Id == "" {
+		return fmt.Errorf("notification not created: %v", out.Errors)
 	}
 	if out.External_id != eid {
-		log.Fatalln("Invalid notification")
+		return fmt.Errorf("invalid notification: external id %s does not match %s", out.External_id, eid)
 	}
-	log.Printf("Pushed notification: %s\n", out.Id)
+	SetResponseID(ctx, out.Id)
+	return nil
 }
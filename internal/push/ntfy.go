@@ -0,0 +1,72 @@
+package push
+
+import (
+	"context"
+	"dilogger/internal/utils"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Priorities of ntfy messages by event priority
+var ntfyPriorities = map[int]int{PriorityLow: 2, PriorityDefault: 3, PriorityHigh: 5}
+
+// Ntfy publishes events to a topic of a ntfy server
+type Ntfy struct {
+	url     string
+	topic   string
+	token   string
+	bigDrop float64
+	client  *http.Client
+}
+
+// Create new Ntfy notifier from NTFY_URL, NTFY_TOPIC and NTFY_TOKEN, fails when no topic is configured
+func NewNtfy() (*Ntfy, error) {
+	topic := utils.GetEnv("NTFY_TOPIC", "")
+	if topic == "" {
+		return nil, fmt.Errorf("%w: NTFY_TOPIC is not set", ErrNotConfigured)
+	}
+	url := strings.TrimSuffix(utils.GetEnv("NTFY_URL", "https://ntfy.sh"), "/")
+	if err := checkURL("NTFY_URL", url); err != nil {
+		return nil, err
+	}
+	return &Ntfy{
+		url:     url,
+		topic:   topic,
+		token:   utils.GetEnv("NTFY_TOKEN", ""),
+		bigDrop: float64(utils.GetEnvInt("NOTIFY_BIG_DROP", 10)),
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Name of the ntfy backend
+func (n *Ntfy) Name() string {
+	return "ntfy"
+}
+
+// The `Notify` function publishes the event as JSON, a click on the notification opens the price chart.
+func (n *Ntfy) Notify(ctx context.Context, event Event) error {
+	tags := []string{"chart_with_upwards_trend"}
+	if event.IsDrop() {
+		tags = []string{"chart_with_downwards_trend"}
+	} else if event.Type != PriceChange {
+		tags = []string{"package"}
+	}
+	message, err := RenderMessage(n.Name(), event, Recipient{})
+	if err != nil {
+		return err
+	}
+	headers := map[string]string{}
+	if n.token != "" {
+		headers["Authorization"] = "Bearer " + n.token
+	}
+	return postJSON(ctx, n.client, n.url, headers, map[string]any{
+		"topic":    n.topic,
+		"title":    message.Title,
+		"message":  message.Body,
+		"priority": ntfyPriorities[event.Priority(n.bigDrop)],
+		"tags":     tags,
+		"click":    event.Link(),
+	})
+}
@@ -0,0 +1,98 @@
+package push
+
+import (
+	"cmp"
+	"slices"
+	"time"
+)
+
+// The `Summarize` function merges the events of each product into at most one price change and one stock event.
+// A price change is kept from the first old price to the last new price and dropped when they are equal,
+// stock events which cancel each other out, like out of stock then back in stock, are dropped too.
+// The other events, like sales, are kept as they are.
+func Summarize(events []Event) []Event {
+	type summary struct {
+		price      *Event
+		firstStock *Event
+		lastStock  *Event
+		others     []Event
+	}
+	var order []string
+	products := map[string]*summary{}
+	for _, event := range events {
+		s, ok := products[event.Product.Id]
+		if !ok {
+			s = &summary{}
+			products[event.Product.Id] = s
+			order = append(order, event.Product.Id)
+		}
+		switch {
+		case event.Type != PriceChange && event.Type != BackInStock && event.Type != OutOfStock:
+			s.others = append(s.others, event)
+		case event.Type != PriceChange:
+			if s.firstStock == nil {
+				s.firstStock = &event
+			}
+			s.lastStock = &event
+		case s.price == nil:
+			s.price = &event
+		default:
+			merged := event
+			merged.OldPrice = s.price.OldPrice
+			merged.Targets = mergeTargets(s.price.Targets, event.Targets)
+			s.price = &merged
+		}
+	}
+	var summarized []Event
+	for _, id := range order {
+		s := products[id]
+		if s.price != nil && s.price.OldPrice != s.price.NewPrice {
+			summarized = append(summarized, *s.price)
+		}
+		if s.lastStock != nil && s.firstStock.Type == s.lastStock.Type {
+			summarized = append(summarized, *s.lastStock)
+		}
+		summarized = append(summarized, s.others...)
+	}
+	return summarized
+}
+
+// The `SaleEvent` function aggregates the price drops of a sale into a single event listing the top largest drops.
+// The sale targets every user targeted by one of the drops.
+func SaleEvent(drops []Event, top int) Event {
+	sorted := slices.Clone(drops)
+	slices.SortStableFunc(sorted, func(a, b Event) int {
+		return cmp.Compare(a.ChangePercent(), b.ChangePercent())
+	})
+	sale := Event{Type: Sale, Time: time.Now(), DropCount: len(drops)}
+	for i, drop := range sorted {
+		if i == 0 {
+			sale.Targets = mergeTargets(drop.Targets, drop.Targets)
+		} else {
+			sale.Targets = mergeTargets(sale.Targets, drop.Targets)
+		}
+		if i < top {
+			drop.Targets = nil
+			sale.Drops = append(sale.Drops, drop)
+		}
+	}
+	return sale
+}
+
+// Merge the users targeted on each backend, nil targets every subscriber
+func mergeTargets(a map[string][]string, b map[string][]string) map[string][]string {
+	if a == nil || b == nil {
+		return nil
+	}
+	merged := map[string][]string{}
+	for _, targets := range []map[string][]string{a, b} {
+		for backend, users := range targets {
+			for _, user := range users {
+				if !slices.Contains(merged[backend], user) {
+					merged[backend] = append(merged[backend], user)
+				}
+			}
+		}
+	}
+	return merged
+}
@@ -0,0 +1,117 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"dilogger/internal/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Telegram sends events as HTML messages to chats through a Telegram bot
+type Telegram struct {
+	apiURL string
+	token  string
+	chats  []string
+	client *http.Client
+}
+
+// Create new Telegram notifier from TELEGRAM_BOT_TOKEN, TELEGRAM_CHAT_IDS and TELEGRAM_API_URL, fails when the bot is not configured
+func NewTelegram() (*Telegram, error) {
+	token := utils.GetEnv("TELEGRAM_BOT_TOKEN", "")
+	chats := utils.SplitList(utils.GetEnv("TELEGRAM_CHAT_IDS", ""), ",")
+	if token == "" || len(chats) == 0 {
+		return nil, fmt.Errorf("%w: TELEGRAM_BOT_TOKEN or TELEGRAM_CHAT_IDS is not set", ErrNotConfigured)
+	}
+	apiURL := strings.TrimSuffix(utils.GetEnv("TELEGRAM_API_URL", "https://api.telegram.org"), "/")
+	if err := checkURL("TELEGRAM_API_URL", apiURL); err != nil {
+		return nil, err
+	}
+	return &Telegram{
+		apiURL: apiURL,
+		token:  token,
+		chats:  chats,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Name of the Telegram backend
+func (t *Telegram) Name() string {
+	return "telegram"
+}
+
+// The `Notify` function sends the rendered event to every chat.
+func (t *Telegram) Notify(ctx context.Context, event Event) error {
+	text, err := t.Render(event)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, chat := range t.chats {
+		if !includes(ctx, chat) {
+			continue
+		}
+		if err := t.send(ctx, chat, text); err != nil {
+			errs = append(errs, recipientError(chat, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Render the HTML message of an event, its title above its body
+func (t *Telegram) Render(event Event) (string, error) {
+	message, err := RenderMessage(t.Name(), event, Recipient{})
+	if err != nil {
+		return "", err
+	}
+	return message.Title + "\n" + message.Body, nil
+}
+
+// Call the sendMessage method of the Bot API for a single chat
+func (t *Telegram) send(ctx context.Context, chat string, text string) error {
+	body, err := json.Marshal(map[string]any{
+		"chat_id":    chat,
+		"text":       text,
+		"parse_mode": "HTML",
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.apiURL+"/bot"+t.token+"/sendMessage", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := t.client.Do(req)
+	if err != nil {
+		// the request URL contains the bot token, so only the cause is returned
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	var out struct {
+		Ok          bool   `json:"ok"`
+		Description string `json:"description"`
+		Result      struct {
+			MessageId int64 `json:"message_id"`
+		} `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return fmt.Errorf("unexpected response %s: %w", resp.Status, err)
+	}
+	if !out.Ok {
+		return fmt.Errorf("telegram error %s: %s", resp.Status, out.Description)
+	}
+	SetResponseID(ctx, strconv.FormatInt(out.Result.MessageId, 10))
+	return nil
+}
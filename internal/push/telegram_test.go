@@ -0,0 +1,70 @@
+package push
+
+import (
+	"context"
+	"dilogger/internal/model"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestTelegramNotify(t *testing.T) {
+	var mu sync.Mutex
+	var chats []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			ChatId    string `json:"chat_id"`
+			Text      string `json:"text"`
+			ParseMode string `json:"parse_mode"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("invalid request body: %v", err)
+		}
+		if r.URL.Path != "/botsecret/sendMessage" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("request %s %s with content type %q", r.Method, r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		if body.ParseMode != "HTML" || !strings.Contains(body.Text, "Blue Kettle") {
+			t.Errorf("request body = %+v", body)
+		}
+		mu.Lock()
+		chats = append(chats, body.ChatId)
+		mu.Unlock()
+		if body.ChatId == "-100" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"ok":false,"description":"Forbidden: bot was kicked from the group chat"}`))
+			return
+		}
+		w.Write([]byte(`{"ok":true,"result":{"message_id":42}}`))
+	}))
+	defer server.Close()
+	t.Setenv("TELEGRAM_BOT_TOKEN", "secret")
+	t.Setenv("TELEGRAM_CHAT_IDS", "1, -100, 2")
+	t.Setenv("TELEGRAM_API_URL", server.URL+"/")
+	telegram, err := NewTelegram()
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := Event{Type: PriceChange, Product: model.Product{Id: "p1", Name: "Blue Kettle"}, OldPrice: 100, NewPrice: 80}
+
+	err = telegram.Notify(context.Background(), event)
+	if failed, ok := FailedRecipients(err); !ok || !slices.Equal(failed, []string{"-100"}) {
+		t.Errorf("Notify() error = %v, want a failure of chat -100 only", err)
+	}
+	if err != nil && strings.Contains(err.Error(), "secret") {
+		t.Errorf("the error contains the bot token: %v", err)
+	}
+	if !slices.Equal(chats, []string{"1", "-100", "2"}) {
+		t.Errorf("sent to chats %v", chats)
+	}
+
+	// a retry only sends to the chat which failed
+	chats = nil
+	telegram.Notify(withRecipient(context.Background(), "-100"), event)
+	if !slices.Equal(chats, []string{"-100"}) {
+		t.Errorf("retry sent to chats %v", chats)
+	}
+}
@@ -0,0 +1,157 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"dilogger/internal/utils"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Delivery attempt of a webhook request
+type Delivery struct {
+	URL        string        `json:"url"`
+	Event      Event         `json:"event"`
+	Attempt    int           `json:"attempt"`
+	StatusCode int           `json:"status_code"`
+	Error      string        `json:"error,omitempty"`
+	Duration   time.Duration `json:"duration"`
+}
+
+// Webhook posts events as signed JSON to a list of URLs
+type Webhook struct {
+	urls       []string
+	headers    map[string]string
+	secret     string
+	retries    int
+	backoff    time.Duration
+	client     *http.Client
+	onDelivery func(Delivery)
+}
+
+// Create new Webhook from WEBHOOK_URLS, WEBHOOK_HEADERS, WEBHOOK_SECRET and WEBHOOK_RETRIES, fails when no URL is configured
+func NewWebhook(onDelivery func(Delivery)) (*Webhook, error) {
+	urls := utils.SplitList(utils.GetEnv("WEBHOOK_URLS", ""), ",")
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("%w: WEBHOOK_URLS is not set", ErrNotConfigured)
+	}
+	for _, url := range urls {
+		if err := checkURL("WEBHOOK_URLS", url); err != nil {
+			return nil, err
+		}
+	}
+	headers := map[string]string{}
+	for _, header := range utils.SplitList(utils.GetEnv("WEBHOOK_HEADERS", ""), ";") {
+		key, value, found := strings.Cut(header, ":")
+		if !found {
+			return nil, fmt.Errorf("invalid webhook header '%s': expected Key: Value", header)
+		}
+		headers[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return &Webhook{
+		urls:       urls,
+		headers:    headers,
+		secret:     utils.GetEnv("WEBHOOK_SECRET", ""),
+		retries:    utils.GetEnvInt("WEBHOOK_RETRIES", 3),
+		backoff:    time.Second,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		onDelivery: onDelivery,
+	}, nil
+}
+
+// Name of the webhook backend
+func (w *Webhook) Name() string {
+	return "webhook"
+}
+
+// The `Notify` function posts the event to every URL, each one retried with exponential backoff.
+func (w *Webhook) Notify(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, url := range w.urls {
+		if !includes(ctx, url) {
+			continue
+		}
+		if err := w.post(ctx, url, event, body); err != nil {
+			errs = append(errs, recipientError(url, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Post the body to a single URL until it succeeds or the retries are exhausted
+func (w *Webhook) post(ctx context.Context, url string, event Event, body []byte) error {
+	var err error
+	for attempt := 1; attempt <= w.retries+1; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-ctx.Done():
+				return errors.Join(err, ctx.Err())
+			case <-time.After(w.backoff << (attempt - 2)):
+			}
+		}
+		var retry bool
+		retry, err = w.attempt(ctx, url, event, body, attempt)
+		if err == nil || !retry {
+			return err
+		}
+	}
+	return err
+}
+
+// Send one request and report whether a failure is worth retrying
+func (w *Webhook) attempt(ctx context.Context, url string, event Event, body []byte, attempt int) (bool, error) {
+	delivery := Delivery{URL: url, Event: event, Attempt: attempt}
+	start := time.Now()
+	defer func() {
+		delivery.Duration = time.Since(start)
+		if w.onDelivery != nil {
+			w.onDelivery(delivery)
+		}
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		delivery.Error = err.Error()
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", event.Type)
+	for key, value := range w.headers {
+		req.Header.Set(key, value)
+	}
+	if w.secret != "" {
+		req.Header.Set("X-Signature-256", "sha256="+Sign(w.secret, body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		delivery.Error = err.Error()
+		return true, err
+	}
+	resp.Body.Close()
+	delivery.StatusCode = resp.StatusCode
+	if resp.StatusCode >= 300 {
+		err = fmt.Errorf("unexpected status %s", resp.Status)
+		delivery.Error = err.Error()
+		return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests, err
+	}
+	return false, nil
+}
+
+// The `Sign` function returns the hex encoded HMAC-SHA256 of the body with the secret.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,58 @@
+package push
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"dilogger/internal/model"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSign(t *testing.T) {
+	// HMAC-SHA256 test vector
+	got := Sign("key", []byte("The quick brown fox jumps over the lazy dog"))
+	if want := "f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8"; got != want {
+		t.Errorf("Sign() = %s, want %s", got, want)
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		body, _ := io.ReadAll(r.Body)
+		// the receiver checks the signature of the body it received with the shared secret
+		mac := hmac.New(sha256.New, []byte("s3cret"))
+		mac.Write(body)
+		if got, want := r.Header.Get("X-Signature-256"), "sha256="+hex.EncodeToString(mac.Sum(nil)); got != want {
+			t.Errorf("X-Signature-256 = %q, want %q", got, want)
+		}
+		if r.Header.Get("X-Webhook-Event") != PriceChange || r.Header.Get("Authorization") != "Bearer abc" {
+			t.Errorf("headers = %v", r.Header)
+		}
+		var event Event
+		if err := json.Unmarshal(body, &event); err != nil || event.Product.Id != "p1" || event.NewPrice != 80 {
+			t.Errorf("body = %s, error = %v", body, err)
+		}
+	}))
+	defer server.Close()
+	t.Setenv("WEBHOOK_URLS", server.URL)
+	t.Setenv("WEBHOOK_HEADERS", "Authorization: Bearer abc")
+	t.Setenv("WEBHOOK_SECRET", "s3cret")
+	webhook, err := NewWebhook(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := Event{Type: PriceChange, Product: model.Product{Id: "p1", Name: "Blue Kettle"}, OldPrice: 100, NewPrice: 80}
+	if err := webhook.Notify(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 1 {
+		t.Errorf("%d requests, want 1", requests)
+	}
+}
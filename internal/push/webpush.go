@@ -0,0 +1,226 @@
+package push
+
+import (
+	"context"
+	"dilogger/internal/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/SherClockHolmes/webpush-go"
+)
+
+// Browser push subscription of a user
+type Subscription struct {
+	Id       string `json:"id"`
+	User     string `json:"user"`
+	Endpoint string `json:"endpoint"`
+	P256dh   string `json:"p256dh"`
+	Auth     string `json:"auth"`
+}
+
+// Hosts of the push services of the browsers used when WEBPUSH_SERVICES is not set, their subdomains are accepted too
+const defaultPushServices = "fcm.googleapis.com,android.googleapis.com,updates.push.services.mozilla.com,notify.windows.com,push.apple.com"
+
+// The `CheckEndpoint` function checks that the endpoint of a subscription is an https URL of a push service listed in WEBPUSH_SERVICES,
+// so the server never posts notifications to another host.
+func CheckEndpoint(endpoint string) error {
+	parsed, err := url.Parse(endpoint)
+	if err != nil || parsed.Scheme != "https" || parsed.Host == "" || parsed.User != nil || (parsed.Port() != "" && parsed.Port() != "443") {
+		return fmt.Errorf("invalid endpoint '%s': expected an https URL of a push service", endpoint)
+	}
+	host := strings.ToLower(parsed.Hostname())
+	for _, service := range utils.SplitList(utils.GetEnv("WEBPUSH_SERVICES", defaultPushServices), ",") {
+		if host == service || strings.HasSuffix(host, "."+service) {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid endpoint '%s': %s is not a known push service", endpoint, host)
+}
+
+// WebPush sends encrypted notifications to the browsers subscribed in the web UI
+type WebPush struct {
+	publicKey     string
+	privateKey    string
+	subject       string
+	bigDrop       float64
+	client        *http.Client
+	subscriptions func(Event) ([]Subscription, error)
+	onGone        func(Subscription)
+}
+
+// Create new WebPush notifier with the VAPID keys, subscriptions which expired are passed to onGone
+func NewWebPush(publicKey string, privateKey string, subscriptions func(Event) ([]Subscription, error), onGone func(Subscription)) (*WebPush, error) {
+	if publicKey == "" || privateKey == "" {
+		return nil, errors.New("VAPID keys are not set")
+	}
+	return &WebPush{
+		publicKey:     publicKey,
+		privateKey:    privateKey,
+		subject:       utils.GetEnv("VAPID_SUBJECT", utils.GetEnv("PB_ADMIN_EMAIL", "admin@example.com")),
+		bigDrop:       float64(utils.GetEnvInt("NOTIFY_BIG_DROP", 10)),
+		client:        &http.Client{Timeout: 10 * time.Second},
+		subscriptions: subscriptions,
+		onGone:        onGone,
+	}, nil
+}
+
+// The `GenerateVAPIDKeys` function creates a new pair of base64 encoded VAPID keys.
+func GenerateVAPIDKeys() (publicKey string, privateKey string, err error) {
+	privateKey, publicKey, err = webpush.GenerateVAPIDKeys()
+	return
+}
+
+// Name of the Web Push backend
+func (w *WebPush) Name() string {
+	return "webpush"
+}
+
+// The `Notify` function encrypts the event for every subscription and sends it to its push service.
+func (w *WebPush) Notify(ctx context.Context, event Event) error {
+	return w.NotifyBatch(ctx, []Event{event})
+}
+
+// The `NotifyBatch` function sends every subscription a single notification, summarizing the events when there are more than one.
+func (w *WebPush) NotifyBatch(ctx context.Context, events []Event) error {
+	var order []string
+	subscriptions := map[string]Subscription{}
+	batches := map[string][]Event{}
+	for _, event := range events {
+		eventSubscriptions, err := w.subscriptions(event)
+		if err != nil {
+			return err
+		}
+		for _, subscription := range eventSubscriptions {
+			if !includes(ctx, subscription.Id) {
+				continue
+			}
+			if _, ok := subscriptions[subscription.Id]; !ok {
+				subscriptions[subscription.Id] = subscription
+				order = append(order, subscription.Id)
+			}
+			batches[subscription.Id] = append(batches[subscription.Id], event)
+		}
+	}
+	var errs []error
+	for _, id := range order {
+		payload, urgency, err := w.payload(batches[id])
+		if err != nil {
+			return err
+		}
+		if err := w.send(ctx, subscriptions[id], payload, urgency); err != nil {
+			errs = append(errs, recipientError(id, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// The `NotifyDigest` function sends the title of the digest to the browsers of its user, a click opens the web UI.
+func (w *WebPush) NotifyDigest(ctx context.Context, digest Digest) error {
+	subscriptions, err := w.subscriptions(Event{Targets: map[string][]string{w.Name(): {digest.User.Id}}})
+	if err != nil {
+		return err
+	}
+	title, text, err := digest.Render()
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(map[string]string{
+		"title": title,
+		"body":  truncate(text, 200),
+		"url":   "/",
+		"tag":   "digest",
+	})
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, subscription := range subscriptions {
+		if !includes(ctx, subscription.Id) {
+			continue
+		}
+		if err := w.send(ctx, subscription, payload, webpush.UrgencyNormal); err != nil {
+			errs = append(errs, recipientError(subscription.Id, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Build the payload of the events with the urgency of the most important one
+func (w *WebPush) payload(events []Event) ([]byte, webpush.Urgency, error) {
+	priority := PriorityLow
+	for _, event := range events {
+		priority = max(priority, event.Priority(w.bigDrop))
+	}
+	urgency := webpush.UrgencyNormal
+	switch priority {
+	case PriorityHigh:
+		urgency = webpush.UrgencyHigh
+	case PriorityLow:
+		urgency = webpush.UrgencyLow
+	}
+	message := map[string]string{
+		"title": fmt.Sprintf("%d product updates", len(events)),
+		"url":   "/",
+		"tag":   "summary",
+	}
+	if len(events) == 1 {
+		event := events[0]
+		rendered, err := RenderMessage(w.Name(), event, Recipient{})
+		if err != nil {
+			return nil, urgency, err
+		}
+		message = map[string]string{
+			"title": rendered.Title,
+			"body":  rendered.Body,
+			"url":   event.Link(),
+			"tag":   event.Product.Id,
+		}
+	} else {
+		var lines []string
+		for _, event := range events {
+			name := event.Product.Name
+			if name == "" {
+				name = event.Title()
+			}
+			lines = append(lines, name+": "+FormatChange(event))
+		}
+		message["body"] = strings.Join(lines, "\n")
+	}
+	payload, err := json.Marshal(message)
+	return payload, urgency, err
+}
+
+// Send the payload to a single subscription
+func (w *WebPush) send(ctx context.Context, subscription Subscription, payload []byte, urgency webpush.Urgency) error {
+	resp, err := webpush.SendNotificationWithContext(ctx, payload, &webpush.Subscription{
+		Endpoint: subscription.Endpoint,
+		Keys:     webpush.Keys{P256dh: subscription.P256dh, Auth: subscription.Auth},
+	}, &webpush.Options{
+		HTTPClient:      w.client,
+		Subscriber:      w.subject,
+		VAPIDPublicKey:  w.publicKey,
+		VAPIDPrivateKey: w.privateKey,
+		TTL:             24 * 60 * 60,
+		Urgency:         urgency,
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	// the browser unsubscribed or the subscription expired
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+		if w.onGone != nil {
+			w.onGone(subscription)
+		}
+		return nil
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("push service %s: unexpected status %s", resp.Request.URL.Host, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package push
+
+import "testing"
+
+func TestCheckEndpoint(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		valid    bool
+	}{
+		{"https://fcm.googleapis.com/fcm/send/abc", true},
+		{"https://updates.push.services.mozilla.com/wpush/v2/abc", true},
+		{"https://wns2-par02p.notify.windows.com/w/?token=abc", true},
+		{"https://web.push.apple.com/abc", true},
+		{"https://FCM.googleapis.com:443/fcm/send/abc", true},
+		{"http://fcm.googleapis.com/fcm/send/abc", false},
+		{"https://fcm.googleapis.com:8443/fcm/send/abc", false},
+		{"https://user@fcm.googleapis.com/fcm/send/abc", false},
+		{"https://evilfcm.googleapis.com.example.com/abc", false},
+		{"https://notfcm.googleapis.com.evil.io/abc", false},
+		{"https://localhost/abc", false},
+		{"https://169.254.169.254/latest/meta-data", false},
+		{"fcm.googleapis.com/fcm/send/abc", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		if err := CheckEndpoint(test.endpoint); (err == nil) != test.valid {
+			t.Errorf("CheckEndpoint(%q) = %v, want valid %v", test.endpoint, err, test.valid)
+		}
+	}
+	t.Setenv("WEBPUSH_SERVICES", "push.example.com")
+	if err := CheckEndpoint("https://eu.push.example.com/abc"); err != nil {
+		t.Errorf("configured service: %v", err)
+	}
+	if err := CheckEndpoint("https://fcm.googleapis.com/fcm/send/abc"); err == nil {
+		t.Error("default service accepted while WEBPUSH_SERVICES is set")
+	}
+}
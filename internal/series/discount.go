@@ -0,0 +1,123 @@
+package series
+
+import (
+	"cmp"
+	"dilogger/internal/model"
+	"slices"
+	"time"
+)
+
+// Qualities of the discount of a price drop
+const (
+	DiscountGenuine     = "genuine"
+	DiscountInflated    = "inflated"
+	DiscountAboveMedian = "above_median"
+)
+
+// Settings of the discount analysis
+type DiscountRules struct {
+	// BumpDays is the window before a drop in which a price increase makes the discount inflated
+	BumpDays int
+	// MinPercent is the drop in percent from which a discount is checked for a prior increase
+	MinPercent float64
+	// MedianDays is the window of the median price which the discounted price must be below
+	MedianDays int
+}
+
+// Days of price history before a drop which are needed to rate it
+func (r DiscountRules) Days() int {
+	return max(r.BumpDays, r.MedianDays)
+}
+
+// The DiscountQuality function rates the last change of the step series until time t, it is empty when that change is not a drop.
+// A drop of at least MinPercent which follows an increase within BumpDays and does not go below the price before the increase is inflated.
+// Otherwise a drop is above_median when the new price is not below the time weighted median price of the MedianDays before it, or genuine.
+func DiscountQuality(points []model.PricePoint, t time.Time, rules DiscountRules) string {
+	seen := until(points, t)
+	last := lastChange(seen)
+	if last <= 0 {
+		return ""
+	}
+	oldPrice, newPrice, changed := seen[last-1].Price, seen[last].Price, seen[last].Time
+	if oldPrice <= 0 || newPrice >= oldPrice {
+		return ""
+	}
+	before := seen[:last]
+	if (oldPrice-newPrice)/oldPrice*100 >= rules.MinPercent {
+		since := changed.AddDate(0, 0, -rules.BumpDays)
+		for i := 1; i < len(before); i++ {
+			if !before[i].Time.Before(since) && before[i].Price > before[i-1].Price && newPrice >= before[i-1].Price {
+				return DiscountInflated
+			}
+		}
+	}
+	if median, ok := median(before, changed.AddDate(0, 0, -rules.MedianDays), changed); ok && newPrice >= median {
+		return DiscountAboveMedian
+	}
+	return DiscountGenuine
+}
+
+// The LastChange function returns the time of the last price change of the step series until time t, ok is false when the price never changed.
+func LastChange(points []model.PricePoint, t time.Time) (changed time.Time, ok bool) {
+	seen := until(points, t)
+	if last := lastChange(seen); last > 0 {
+		return seen[last].Time, true
+	}
+	return time.Time{}, false
+}
+
+// Points of the step series until time t
+func until(points []model.PricePoint, t time.Time) []model.PricePoint {
+	for i, point := range points {
+		if point.Time.After(t) {
+			return points[:i]
+		}
+	}
+	return points
+}
+
+// Index of the last change, which is the first point of the last run of equal prices, zero or less without change
+func lastChange(points []model.PricePoint) int {
+	last := len(points) - 1
+	for last > 0 && points[last-1].Price == points[last].Price {
+		last--
+	}
+	return last
+}
+
+// Time weighted median of the step series between from and to
+func median(points []model.PricePoint, from, to time.Time) (float64, bool) {
+	type segment struct {
+		price    float64
+		duration time.Duration
+	}
+	var segments []segment
+	var total time.Duration
+	for i, point := range points {
+		end := to
+		if i+1 < len(points) {
+			end = points[i+1].Time
+		}
+		start := point.Time
+		if start.Before(from) {
+			start = from
+		}
+		if !end.After(start) {
+			continue
+		}
+		segments = append(segments, segment{point.Price, end.Sub(start)})
+		total += end.Sub(start)
+	}
+	if total == 0 {
+		return 0, false
+	}
+	slices.SortFunc(segments, func(a, b segment) int { return cmp.Compare(a.price, b.price) })
+	var cumulated time.Duration
+	for _, segment := range segments {
+		cumulated += segment.duration
+		if cumulated*2 >= total {
+			return segment.price, true
+		}
+	}
+	return segments[len(segments)-1].price, true
+}
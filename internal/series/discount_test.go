@@ -0,0 +1,90 @@
+package series
+
+import (
+	"dilogger/internal/model"
+	"testing"
+	"time"
+)
+
+// Step series with a point at the given days after base for each price
+func days(prices map[int]float64) []model.PricePoint {
+	var points []model.PricePoint
+	for day := 0; day <= 365; day++ {
+		if price, ok := prices[day]; ok {
+			points = append(points, model.PricePoint{Time: base.AddDate(0, 0, day), Price: price})
+		}
+	}
+	return points
+}
+
+func TestDiscountQuality(t *testing.T) {
+	rules := DiscountRules{BumpDays: 14, MinPercent: 10, MedianDays: 60}
+	tests := []struct {
+		name   string
+		points []model.PricePoint
+		day    int
+		want   string
+	}{
+		{"no change", days(map[int]float64{0: 100}), 10, ""},
+		{"increase", days(map[int]float64{0: 100, 60: 120}), 61, ""},
+		{"genuine drop", days(map[int]float64{0: 100, 60: 80}), 61, DiscountGenuine},
+		{"drop after t", days(map[int]float64{0: 100, 60: 80}), 59, ""},
+		{"drop followed by the same price", days(map[int]float64{0: 100, 60: 80, 70: 80}), 75, DiscountGenuine},
+		{"last change is an increase", days(map[int]float64{0: 100, 60: 80, 70: 90}), 75, ""},
+		{"drop after a recent increase", days(map[int]float64{0: 100, 55: 130, 60: 110}), 61, DiscountInflated},
+		{"drop after an old increase", days(map[int]float64{0: 100, 30: 130, 60: 110}), 61, DiscountAboveMedian},
+		{"small drop after an increase", days(map[int]float64{0: 100, 55: 105, 60: 100}), 61, DiscountAboveMedian},
+		{"drop below the price before the increase", days(map[int]float64{0: 100, 55: 130, 60: 90}), 61, DiscountGenuine},
+		{"drop to the median", days(map[int]float64{0: 100, 40: 120, 60: 100}), 61, DiscountAboveMedian},
+	}
+	for _, test := range tests {
+		if got := DiscountQuality(test.points, base.AddDate(0, 0, test.day), rules); got != test.want {
+			t.Errorf("%s: DiscountQuality() = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestLastChange(t *testing.T) {
+	points := days(map[int]float64{0: 100, 10: 90, 20: 90, 30: 80})
+	tests := []struct {
+		day     int
+		changed time.Time
+		ok      bool
+	}{
+		{5, time.Time{}, false},
+		{15, base.AddDate(0, 0, 10), true},
+		{25, base.AddDate(0, 0, 10), true},
+		{30, base.AddDate(0, 0, 30), true},
+	}
+	for _, test := range tests {
+		changed, ok := LastChange(points, base.AddDate(0, 0, test.day))
+		if !changed.Equal(test.changed) || ok != test.ok {
+			t.Errorf("LastChange(day %d) = %v, %v, want %v, %v", test.day, changed, ok, test.changed, test.ok)
+		}
+	}
+}
+
+func TestMedian(t *testing.T) {
+	tests := []struct {
+		name     string
+		points   []model.PricePoint
+		from, to int
+		want     float64
+		ok       bool
+	}{
+		{"no point", nil, 0, 10, 0, false},
+		{"empty range", days(map[int]float64{0: 100}), 10, 10, 0, false},
+		{"price before the range", days(map[int]float64{0: 100}), 10, 20, 100, true},
+		{"longest price", days(map[int]float64{0: 100, 1: 50}), 0, 4, 50, true},
+		{"weighted by duration", days(map[int]float64{0: 50, 3: 100}), 0, 4, 50, true},
+		{"lower of two halves", days(map[int]float64{0: 130, 30: 100}), 0, 60, 100, true},
+		{"three prices", days(map[int]float64{0: 300, 10: 100, 20: 200}), 0, 30, 200, true},
+		{"only the range counts", days(map[int]float64{0: 50, 10: 100, 20: 200}), 15, 30, 200, true},
+	}
+	for _, test := range tests {
+		got, ok := median(test.points, base.AddDate(0, 0, test.from), base.AddDate(0, 0, test.to))
+		if got != test.want || ok != test.ok {
+			t.Errorf("%s: median() = %v, %v, want %v, %v", test.name, got, ok, test.want, test.ok)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package series
+
+import (
+	"dilogger/internal/model"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Bucket is the time width used to group price points
+type Bucket string
+
+const (
+	Raw  Bucket = ""
+	Hour Bucket = "hour"
+	Day  Bucket = "day"
+	Week Bucket = "week"
+)
+
+// The ParseBucket function validates a bucket name received from a request.
+func ParseBucket(name string) (Bucket, error) {
+	switch b := Bucket(name); b {
+	case Raw, Hour, Day, Week:
+		return b, nil
+	}
+	return Raw, fmt.Errorf("invalid bucket '%s': expected hour, day or week", name)
+}
+
+// The Start function truncates the time to the beginning of its bucket in the time's location.
+func (b Bucket) Start(t time.Time) time.Time {
+	switch b {
+	case Hour:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+	case Day:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	case Week:
+		// weeks start on monday
+		offset := (int(t.Weekday()) + 6) % 7
+		return time.Date(t.Year(), t.Month(), t.Day()-offset, 0, 0, 0, 0, t.Location())
+	}
+	return t
+}
+
+// The Next function returns the beginning of the bucket following the one starting at t.
+func (b Bucket) Next(t time.Time) time.Time {
+	switch b {
+	case Hour:
+		return t.Add(time.Hour)
+	case Day:
+		return t.AddDate(0, 0, 1)
+	case Week:
+		return t.AddDate(0, 0, 7)
+	}
+	return t
+}
+
+// The PriceAt function returns the price in effect at the given time.
+// Among the intervals containing t the most recently started one wins, otherwise the last seen price is carried forward.
+func PriceAt(intervals []model.PriceInterval, t time.Time) (float64, bool) {
+	var current, previous *model.PriceInterval
+	for i := range intervals {
+		iv := &intervals[i]
+		if !iv.Start.After(t) && !iv.End.Before(t) {
+			if current == nil || iv.Start.After(current.Start) {
+				current = iv
+			}
+		} else if iv.End.Before(t) {
+			if previous == nil || iv.End.After(previous.End) {
+				previous = iv
+			}
+		}
+	}
+	if current != nil {
+		return current.Price, true
+	}
+	if previous != nil {
+		return previous.Price, true
+	}
+	return 0, false
+}
+
+// The Points function lists every observed change point between from and to, without bucketing.
+// The price in effect at from is included so the series starts at the beginning of the range.
+// The prices are read from a single walk along Changes, like in Fill.
+func Points(intervals []model.PriceInterval, from, to time.Time) []model.PricePoint {
+	points := []model.PricePoint{}
+	changes := Changes(intervals)
+	times := []time.Time{from}
+	for _, iv := range intervals {
+		for _, t := range []time.Time{iv.Start, iv.End} {
+			if !t.Before(from) && !t.After(to) {
+				times = append(times, t)
+			}
+		}
+	}
+	slices.SortFunc(times, func(a, b time.Time) int { return a.Compare(b) })
+	times = slices.CompactFunc(times, func(a, b time.Time) bool { return a.Equal(b) })
+	next := 0
+	for _, t := range times {
+		for next < len(changes) && !changes[next].Time.After(t) {
+			next++
+		}
+		if next > 0 {
+			points = append(points, model.PricePoint{Time: t, Price: changes[next-1].Price})
+		}
+	}
+	return points
+}
+
+// The Fill function builds a gap-filled series with one OHLC point per bucket between from and to.
+// Buckets before the first observation are skipped, later gaps carry the last seen price forward.
+func Fill(intervals []model.PriceInterval, b Bucket, from, to time.Time) []model.PricePoint {
+	points := []model.PricePoint{}
+	if len(intervals) == 0 || b == Raw {
+		return points
+	}
+	changes := Changes(intervals)
+	if from.Before(changes[0].Time) {
+		from = changes[0].Time
+	}
+	// walk the changes once along the buckets
+	next := 0
+	var price float64
+	seen := false
+	for start := b.Start(from); start.Before(to); start = b.Next(start) {
+		end := b.Next(start)
+		if end.After(to) {
+			end = to
+		}
+		for next < len(changes) && !changes[next].Time.After(start) {
+			price, seen = changes[next].Price, true
+			next++
+		}
+		point := model.PricePoint{Time: start, Price: price, Open: price, High: price, Low: price}
+		open := seen
+		for next < len(changes) && changes[next].Time.Before(end) {
+			price, seen = changes[next].Price, true
+			next++
+			if !open {
+				point = model.PricePoint{Time: start, Open: price, High: price, Low: price}
+				open = true
+			}
+			point.High = max(point.High, price)
+			point.Low = min(point.Low, price)
+			point.Price = price
+		}
+		if open {
+			points = append(points, point)
+		}
+	}
+	return points
+}
+
+// The Changes function lists the times at which the price in effect changes, with the price from then on.
+// It sweeps the sorted intervals once and gives the same prices as PriceAt.
+func Changes(intervals []model.PriceInterval) []model.PricePoint {
+	// among intervals starting together the first one wins, so it is pushed last
+	byStart := make([]int, len(intervals))
+	byEnd := make([]int, len(intervals))
+	var times []time.Time
+	for i, iv := range intervals {
+		byStart[i], byEnd[i] = i, i
+		// the end is included, so the price may change right after it
+		times = append(times, iv.Start, iv.End.Add(time.Nanosecond))
+	}
+	slices.SortFunc(byStart, func(a, b int) int {
+		if c := intervals[a].Start.Compare(intervals[b].Start); c != 0 {
+			return c
+		}
+		return b - a
+	})
+	slices.SortStableFunc(byEnd, func(a, b int) int { return intervals[a].End.Compare(intervals[b].End) })
+	slices.SortFunc(times, func(a, b time.Time) int { return a.Compare(b) })
+	times = slices.CompactFunc(times, func(a, b time.Time) bool { return a.Equal(b) })
+
+	changes := []model.PricePoint{}
+	var active []int
+	nextStart, nextEnd, previous := 0, 0, -1
+	for _, t := range times {
+		for nextStart < len(byStart) && !intervals[byStart[nextStart]].Start.After(t) {
+			active = append(active, byStart[nextStart])
+			nextStart++
+		}
+		// the most recently started interval still running is in effect
+		for len(active) > 0 && intervals[active[len(active)-1]].End.Before(t) {
+			active = active[:len(active)-1]
+		}
+		for nextEnd < len(byEnd) && intervals[byEnd[nextEnd]].End.Before(t) {
+			if previous < 0 || intervals[byEnd[nextEnd]].End.After(intervals[previous].End) {
+				previous = byEnd[nextEnd]
+			}
+			nextEnd++
+		}
+		current := previous
+		if len(active) > 0 {
+			current = active[len(active)-1]
+		}
+		if current < 0 {
+			continue
+		}
+		price := intervals[current].Price
+		if n := len(changes); n == 0 || changes[n-1].Price != price {
+			changes = append(changes, model.PricePoint{Time: t, Price: price})
+		}
+	}
+	return changes
+}
+
+// The Merge function regroups bucketed points into a wider bucket.
+func Merge(points []model.PricePoint, b Bucket) []model.PricePoint {
+	merged := []model.PricePoint{}
+	for _, p := range points {
+		start := b.Start(p.Time)
+		if n := len(merged); n > 0 && merged[n-1].Time.Equal(start) {
+			last := &merged[n-1]
+			last.High = max(last.High, p.High)
+			last.Low = min(last.Low, p.Low)
+			last.Price = p.Price
+			continue
+		}
+		p.Time = start
+		merged = append(merged, p)
+	}
+	return merged
+}
@@ -0,0 +1,134 @@
+package series
+
+import (
+	"dilogger/internal/model"
+	"slices"
+	"testing"
+	"time"
+)
+
+var base = time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC)
+
+// Time at the given minutes after base
+func at(minutes int) time.Time {
+	return base.Add(time.Duration(minutes) * time.Minute)
+}
+
+func interval(price float64, start, end int) model.PriceInterval {
+	return model.PriceInterval{Price: price, Start: at(start), End: at(end)}
+}
+
+func TestChanges(t *testing.T) {
+	tests := []struct {
+		name      string
+		intervals []model.PriceInterval
+		want      []model.PricePoint
+	}{
+		{"no interval", nil, []model.PricePoint{}},
+		{"single interval", []model.PriceInterval{interval(100, 60, 120)},
+			[]model.PricePoint{{Time: at(60), Price: 100}}},
+		{"consecutive intervals", []model.PriceInterval{interval(100, 0, 120), interval(90, 180, 300)},
+			[]model.PricePoint{{Time: at(0), Price: 100}, {Time: at(180), Price: 90}}},
+		{"equal prices are merged", []model.PriceInterval{interval(100, 0, 60), interval(100, 120, 180)},
+			[]model.PricePoint{{Time: at(0), Price: 100}}},
+		{"revived price around a change", []model.PriceInterval{interval(100, 0, 600), interval(90, 120, 240)},
+			[]model.PricePoint{{Time: at(0), Price: 100}, {Time: at(120), Price: 90}, {Time: at(240).Add(time.Nanosecond), Price: 100}}},
+		{"first of intervals starting together wins", []model.PriceInterval{interval(100, 0, 60), interval(90, 0, 60)},
+			[]model.PricePoint{{Time: at(0), Price: 100}}},
+		{"unsorted intervals", []model.PriceInterval{interval(90, 180, 300), interval(100, 0, 120)},
+			[]model.PricePoint{{Time: at(0), Price: 100}, {Time: at(180), Price: 90}}},
+	}
+	for _, test := range tests {
+		got := Changes(test.intervals)
+		if !slices.EqualFunc(got, test.want, samePoint) {
+			t.Errorf("%s: Changes() = %v, want %v", test.name, got, test.want)
+		}
+		// the changes give the same prices as PriceAt
+		for _, change := range got {
+			if price, _ := PriceAt(test.intervals, change.Time); price != change.Price {
+				t.Errorf("%s: PriceAt(%v) = %v, want %v", test.name, change.Time, price, change.Price)
+			}
+		}
+	}
+}
+
+func TestFill(t *testing.T) {
+	intervals := []model.PriceInterval{interval(100, 30, 70), interval(90, 80, 180)}
+	ohlc := func(minutes int, open, high, low, close float64) model.PricePoint {
+		return model.PricePoint{Time: at(minutes), Open: open, High: high, Low: low, Price: close}
+	}
+	tests := []struct {
+		name      string
+		intervals []model.PriceInterval
+		bucket    Bucket
+		from, to  time.Time
+		want      []model.PricePoint
+	}{
+		{"no interval", nil, Hour, at(0), at(240), []model.PricePoint{}},
+		{"raw bucket", intervals, Raw, at(0), at(240), []model.PricePoint{}},
+		{"hours", intervals, Hour, at(0), at(240), []model.PricePoint{
+			ohlc(0, 100, 100, 100, 100),
+			ohlc(60, 100, 100, 90, 90),
+			ohlc(120, 90, 90, 90, 90),
+			// the last price is carried forward after the last interval
+			ohlc(180, 90, 90, 90, 90),
+		}},
+		// the buckets are whole, so the first one opens before from
+		{"range starting inside a bucket", intervals, Hour, at(90), at(150), []model.PricePoint{
+			ohlc(60, 100, 100, 90, 90),
+			ohlc(120, 90, 90, 90, 90),
+		}},
+		{"range ending inside a bucket", intervals, Hour, at(0), at(75), []model.PricePoint{
+			ohlc(0, 100, 100, 100, 100),
+			ohlc(60, 100, 100, 100, 100),
+		}},
+		{"range before the first interval", intervals, Hour, at(-180), at(60), []model.PricePoint{
+			ohlc(0, 100, 100, 100, 100),
+		}},
+		{"day", []model.PriceInterval{interval(100, 60, 600), interval(80, 700, 800), interval(120, 900, 1000)}, Day, at(0), at(1440), []model.PricePoint{
+			ohlc(0, 100, 120, 80, 120),
+		}},
+	}
+	for _, test := range tests {
+		got := Fill(test.intervals, test.bucket, test.from, test.to)
+		if !slices.EqualFunc(got, test.want, samePoint) {
+			t.Errorf("%s: Fill() = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestPoints(t *testing.T) {
+	point := func(minutes int, price float64) model.PricePoint {
+		return model.PricePoint{Time: at(minutes), Price: price}
+	}
+	tests := []struct {
+		name      string
+		intervals []model.PriceInterval
+		from, to  time.Time
+		want      []model.PricePoint
+	}{
+		{"no interval", nil, at(0), at(240), []model.PricePoint{}},
+		{"range before the first interval", []model.PriceInterval{interval(100, 60, 120)}, at(0), at(240),
+			[]model.PricePoint{point(60, 100), point(120, 100)}},
+		{"price in effect at from", []model.PriceInterval{interval(100, 0, 120), interval(90, 180, 300)}, at(60), at(240),
+			[]model.PricePoint{point(60, 100), point(120, 100), point(180, 90)}},
+		// the revived 100 record ends after the 90 record, so 100 is in effect again at its end
+		{"revived price around a change", []model.PriceInterval{interval(100, 0, 600), interval(90, 120, 240)}, at(0), at(600),
+			[]model.PricePoint{point(0, 100), point(120, 90), point(240, 90), point(600, 100)}},
+	}
+	for _, test := range tests {
+		got := Points(test.intervals, test.from, test.to)
+		if !slices.EqualFunc(got, test.want, samePoint) {
+			t.Errorf("%s: Points() = %v, want %v", test.name, got, test.want)
+		}
+		for _, point := range got {
+			if price, _ := PriceAt(test.intervals, point.Time); price != point.Price {
+				t.Errorf("%s: PriceAt(%v) = %v, want %v", test.name, point.Time, price, point.Price)
+			}
+		}
+	}
+}
+
+func samePoint(a, b model.PricePoint) bool {
+	return a.Time.Equal(b.Time) && a.Price == b.Price && a.Open == b.Open && a.High == b.High && a.Low == b.Low
+}
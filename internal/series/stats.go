@@ -0,0 +1,84 @@
+package series
+
+import (
+	"dilogger/internal/model"
+	"math"
+	"time"
+)
+
+// The StatsAt function computes the statistics of a product as they were at time t from its change points.
+// The windows of the statistics computed from the database come from it too, the other statistics mirror the database query.
+func StatsAt(points []model.PricePoint, t time.Time) model.ProductStats {
+	var stats model.ProductStats
+	var seen []model.PricePoint
+	for _, point := range points {
+		if point.Time.After(t) {
+			break
+		}
+		seen = append(seen, point)
+	}
+	if len(seen) == 0 {
+		return stats
+	}
+	stats.Current = seen[len(seen)-1].Price
+	stats.AllTimeLow = model.PriceExtreme{Price: math.Inf(1)}
+	for i, point := range seen {
+		if i > 0 && point.Price != seen[i-1].Price {
+			stats.Changes++
+			stats.LastChanged = point.Time
+		}
+		if point.Price < stats.AllTimeLow.Price {
+			stats.AllTimeLow = model.PriceExtreme{Price: point.Price, FirstSeen: point.Time}
+		}
+		if point.Price > stats.AllTimeHigh.Price {
+			stats.AllTimeHigh = model.PriceExtreme{Price: point.Price, FirstSeen: point.Time}
+		}
+		if point.Price == stats.AllTimeLow.Price {
+			stats.AllTimeLow.LastSeen = point.Time
+		}
+		if point.Price == stats.AllTimeHigh.Price {
+			stats.AllTimeHigh.LastSeen = point.Time
+		}
+	}
+	if stats.LastChanged.IsZero() {
+		stats.LastChanged = seen[0].Time
+	}
+	stats.SinceLastChange = int64(t.Sub(stats.LastChanged).Seconds())
+	stats.Last7Days = window(seen, t.AddDate(0, 0, -7), t)
+	stats.Last30Days = window(seen, t.AddDate(0, 0, -30), t)
+	stats.Last90Days = window(seen, t.AddDate(0, 0, -90), t)
+	stats.At30DayLow = stats.Current <= stats.Last30Days.Min && stats.Last30Days.Min < stats.Last30Days.Avg
+	return stats
+}
+
+// Minimum and time weighted average of the step series between from and to
+func window(points []model.PricePoint, from, to time.Time) model.WindowStats {
+	stats := model.WindowStats{Min: math.Inf(1)}
+	var sum, weight float64
+	for i, point := range points {
+		end := to
+		if i+1 < len(points) {
+			end = points[i+1].Time
+		}
+		if end.Before(from) {
+			continue
+		}
+		start := point.Time
+		if start.Before(from) {
+			start = from
+		}
+		stats.Min = min(stats.Min, point.Price)
+		duration := end.Sub(start).Seconds()
+		sum += point.Price * duration
+		weight += duration
+	}
+	switch {
+	case math.IsInf(stats.Min, 1):
+		return model.WindowStats{}
+	case weight > 0:
+		stats.Avg = sum / weight
+	default:
+		stats.Avg = stats.Min
+	}
+	return stats
+}
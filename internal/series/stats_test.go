@@ -0,0 +1,27 @@
+package series
+
+import (
+	"dilogger/internal/model"
+	"testing"
+	"time"
+)
+
+func TestStatsAtFlappingPrice(t *testing.T) {
+	// 100 until 90 at 100, then the 100 record is revived until 200 and the 90 record until 300,
+	// so the records overlap and the price in effect is 90 from 100 on
+	intervals := []model.PriceInterval{interval(100, 0, 200), interval(90, 100, 300)}
+	now := at(400)
+	want := model.WindowStats{Min: 90, Avg: (100*100 + 90*300) / 400.0}
+	// the alerts compute the statistics from the whole history, the product statistics from the last 90 days
+	for name, from := range map[string]time.Time{"history": time.Unix(0, 0), "90 days": now.AddDate(0, 0, -90)} {
+		stats := StatsAt(Points(intervals, from, now), now)
+		for days, got := range map[int]model.WindowStats{7: stats.Last7Days, 30: stats.Last30Days, 90: stats.Last90Days} {
+			if got != want {
+				t.Errorf("%s: %d days window = %+v, want %+v", name, days, got, want)
+			}
+		}
+		if stats.Current != 90 || !stats.At30DayLow {
+			t.Errorf("%s: current = %v, at 30 day low = %v, want 90, true", name, stats.Current, stats.At30DayLow)
+		}
+	}
+}
@@ -20,6 +20,27 @@ func GetEnv(key string, fallback ...string) string {
 	return value
 }
 
+// The `GetEnvInt` function retrieves an integer environment variable or returns the fallback value if it is not set or invalid.
+func GetEnvInt(key string, fallback int) int {
+	value, err := strconv.Atoi(GetEnv(key, strconv.Itoa(fallback)))
+	if err != nil {
+		log.Printf("warning: Environment variable '%s' is not a number, using %d", key, fallback)
+		return fallback
+	}
+	return value
+}
+
+// The `SplitList` function splits a list by the separator, trimming the items and dropping the empty ones.
+func SplitList(value string, sep string) []string {
+	var items []string
+	for _, item := range strings.Split(value, sep) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 // The IsSUDO function checks if the current process is running with root privileges.
 func IsSUDO() bool {
 	stdout, err := exec.Command("ps", "-o", "user=", "-p", strconv.Itoa(os.Getpid())).Output()